@@ -0,0 +1,211 @@
+package adaptive
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	"sync"
+	"time"
+)
+
+// saturatedPool is the share of postgres connections in use above which more workers
+// would only queue for a connection
+const saturatedPool = 0.9
+
+// Limits are the worker count and pull batch size currently in use
+type Limits struct {
+	Workers int
+	Batch   int
+}
+
+// Stats are the signals the controller adjusts to
+type Stats struct {
+	// PoolSaturation is the share of the postgres pool's connections in use
+	PoolSaturation float64
+	// Pending is the number of messages the consumer has not delivered yet
+	Pending uint64
+	// MaxAckPending caps the messages delivered but not acked yet, -1 means no limit
+	MaxAckPending int
+}
+
+// Sampler reads the current stats
+type Sampler func(ctx context.Context) (Stats, error)
+
+// Controller sizes the worker pool and pull batches with additive increase, multiplicative
+// decrease: it adds a worker while a backlog builds up, removes a quarter of them when
+// processing slows down or the postgres pool saturates, and slowly shrinks when idle
+type Controller struct {
+	consumer      string
+	minWorkers    int
+	maxWorkers    int
+	maxBatch      int
+	interval      time.Duration
+	targetLatency time.Duration
+	batchMode     bool
+
+	mu            sync.Mutex
+	limits        Limits
+	maxAckPending int
+	inFlight      int
+	latencySum    time.Duration
+	latencyCount  int
+}
+
+// NewController starts with cfg.Workers workers, bounded by the consumer's MaxAckPending.
+// Later changes of MaxAckPending are picked up from the samples.
+func NewController(consumer string, cfg config.Consumer, maxAckPending int) *Controller {
+	c := &Controller{
+		consumer:      consumer,
+		minWorkers:    cfg.MinWorkers,
+		maxWorkers:    cfg.MaxWorkers,
+		maxBatch:      cfg.MaxBatch,
+		interval:      cfg.AdjustInterval,
+		targetLatency: cfg.TargetLatency,
+		batchMode:     cfg.Mode == config.ConsumerModeBatch,
+		maxAckPending: maxAckPending,
+	}
+	c.limits = c.bound(cfg.Workers)
+	metrics.SetConsumerLimits(c.consumer, c.limits.Workers, c.limits.Batch)
+
+	return c
+}
+
+// Limits returns the limits currently in use
+func (c *Controller) Limits() Limits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limits
+}
+
+// Reserve returns how many messages can be pulled without exceeding the batch size or MaxAckPending,
+// and counts them as in flight. Every reserved message must be released with Done.
+func (c *Controller) Reserve() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := min(c.limits.Batch, c.ackLimit()-c.inFlight)
+	if n < 0 {
+		n = 0
+	}
+	c.inFlight += n
+	return n
+}
+
+// Unreserve returns reserved slots that were not used because a fetch returned fewer messages
+func (c *Controller) Unreserve(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight -= n
+}
+
+// Done releases the slots of n settled messages and records how long it took until they were settled
+func (c *Controller) Done(n int, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight -= n
+	c.latencySum += time.Duration(n) * latency
+	c.latencyCount += n
+}
+
+// Run adjusts the limits every interval until ctx is cancelled, resize is called with the
+// new worker count whenever it changes
+func (c *Controller) Run(ctx context.Context, sample Sampler, resize func(workers int)) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sampleCtx, cancel := context.WithTimeout(ctx, c.interval)
+		stats, err := sample(sampleCtx)
+		cancel()
+		if err != nil {
+			slog.Warn("error sampling consumer stats, keeping the current limits", "consumer", c.consumer, logging.KeyError, err)
+			continue
+		}
+
+		if limits, changed := c.adjust(stats); changed {
+			resize(limits.Workers)
+		}
+	}
+}
+
+// adjust computes the next limits from stats and the latency observed since the last adjustment
+func (c *Controller) adjust(stats Stats) (Limits, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var latency time.Duration
+	if c.latencyCount > 0 {
+		latency = c.latencySum / time.Duration(c.latencyCount)
+	}
+	c.latencySum, c.latencyCount = 0, 0
+	if stats.MaxAckPending != 0 {
+		c.maxAckPending = stats.MaxAckPending
+	}
+
+	workers := c.limits.Workers
+	reason := "steady"
+	switch {
+	case latency > c.targetLatency:
+		workers -= max(workers/4, 1)
+		reason = "processing latency above target"
+	case stats.PoolSaturation >= saturatedPool:
+		workers -= max(workers/4, 1)
+		reason = "postgres pool saturated"
+	case stats.Pending > uint64(workers):
+		workers++
+		reason = "backlog growing"
+	case stats.Pending == 0 && c.inFlight == 0:
+		workers--
+		reason = "idle"
+	}
+
+	previous := c.limits
+	c.limits = c.bound(workers)
+	c.limits.Batch = min(c.limits.Batch, max(int(stats.Pending), 1))
+	metrics.SetConsumerLimits(c.consumer, c.limits.Workers, c.limits.Batch)
+
+	if c.limits == previous {
+		return c.limits, false
+	}
+	slog.Info("adjusted consumer limits", "consumer", c.consumer, "reason", reason,
+		"workers", c.limits.Workers, "batch", c.limits.Batch, "previous_workers", previous.Workers,
+		"previous_batch", previous.Batch, "latency", latency, "pool_saturation", stats.PoolSaturation,
+		"pending", stats.Pending, "max_ack_pending", c.maxAckPending)
+
+	return c.limits, c.limits.Workers != previous.Workers
+}
+
+// bound keeps the worker count within the configured bounds and MaxAckPending, workers beyond
+// MaxAckPending would never receive a message. In message mode a batch fills every worker once,
+// in batch mode every worker takes a whole batch and together they fill MaxAckPending.
+func (c *Controller) bound(workers int) Limits {
+	upper := min(c.maxWorkers, c.ackLimit())
+	workers = max(min(workers, upper), min(c.minWorkers, upper), 1)
+
+	batch := workers
+	if c.batchMode {
+		batch = c.ackLimit() / workers
+	}
+
+	return Limits{
+		Workers: workers,
+		Batch:   max(min(batch, c.maxBatch, c.ackLimit()), 1),
+	}
+}
+
+// ackLimit returns MaxAckPending, where -1 means the consumer has no limit
+func (c *Controller) ackLimit() int {
+	if c.maxAckPending < 0 {
+		return math.MaxInt32
+	}
+	return c.maxAckPending
+}
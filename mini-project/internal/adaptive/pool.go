@@ -0,0 +1,73 @@
+package adaptive
+
+import (
+	"sync"
+)
+
+// Pool runs a resizable number of workers reading from the same channel,
+// T is a single message or a whole batch depending on the consumer mode
+type Pool[T any] struct {
+	msgs   <-chan T
+	handle func(T)
+
+	mu   sync.Mutex
+	size int
+	// stop holds one token per worker asked to exit, a worker takes it once it is idle
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPool creates a pool for up to maxWorkers workers, none are started until Resize
+func NewPool[T any](msgs <-chan T, maxWorkers int, handle func(T)) *Pool[T] {
+	return &Pool[T]{
+		msgs:   msgs,
+		handle: handle,
+		stop:   make(chan struct{}, maxWorkers),
+	}
+}
+
+// Resize starts or stops workers until n are running. Stopped workers finish their current message first.
+func (p *Pool[T]) Resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.size < n {
+		// Take back a stop token no worker picked up yet before starting a new worker
+		select {
+		case <-p.stop:
+		default:
+			p.wg.Go(p.work)
+		}
+		p.size++
+	}
+	for p.size > n {
+		p.stop <- struct{}{}
+		p.size--
+	}
+}
+
+// Size returns the number of workers the pool is sized to
+func (p *Pool[T]) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// Wait blocks until every worker exited, workers exit once the channel is closed and drained
+func (p *Pool[T]) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pool[T]) work() {
+	for {
+		select {
+		case <-p.stop:
+			return
+		case msg, ok := <-p.msgs:
+			if !ok {
+				return
+			}
+			p.handle(msg)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/logging"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Hook is one component of a service. Start runs when the service starts, in the order
+// the hooks were appended, and Stop runs on shutdown in the reverse order, so a component
+// is stopped before the components it depends on. Either function may be nil.
+type Hook struct {
+	Name  string
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+}
+
+// App runs the hooks of a service until it receives SIGINT or SIGTERM
+type App struct {
+	shutdownTimeout time.Duration
+	hooks           []Hook
+	started         int
+}
+
+func New(shutdownTimeout time.Duration) *App {
+	return &App{shutdownTimeout: shutdownTimeout}
+}
+
+// Append adds a hook, append the components a hook depends on first
+func (a *App) Append(hook Hook) {
+	a.hooks = append(a.hooks, hook)
+}
+
+// Run starts every hook, waits until ctx is cancelled or the process is signalled and then
+// stops the started hooks. The whole shutdown must finish within the shutdown timeout.
+func (a *App) Run(ctx context.Context) error {
+	signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := a.Start(signalCtx); err != nil {
+		return errors.Join(err, a.shutdown())
+	}
+
+	<-signalCtx.Done()
+	slog.Info("shutting down")
+
+	return a.shutdown()
+}
+
+func (a *App) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+	return a.Stop(ctx)
+}
+
+// Start runs the start functions in order and stops at the first failure,
+// hooks started until then are still stopped by Stop
+func (a *App) Start(ctx context.Context) error {
+	for _, hook := range a.hooks[a.started:] {
+		if hook.Start != nil {
+			if err := hook.Start(ctx); err != nil {
+				return fmt.Errorf("starting %s: %w", hook.Name, err)
+			}
+		}
+		a.started++
+	}
+
+	return nil
+}
+
+// Stop runs the stop functions of the started hooks in reverse order. A failing or
+// timed out hook does not keep the remaining hooks from stopping.
+func (a *App) Stop(ctx context.Context) error {
+	var errs []error
+	for ; a.started > 0; a.started-- {
+		hook := a.hooks[a.started-1]
+		if hook.Stop == nil {
+			continue
+		}
+
+		if err := stopHook(ctx, hook); err != nil {
+			slog.Error("error stopping "+hook.Name, logging.KeyError, err)
+			errs = append(errs, fmt.Errorf("stopping %s: %w", hook.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// stopHook returns once the hook stopped or the deadline passed, whichever comes first.
+// Past the deadline hooks are still asked to stop, but nothing waits for them.
+func stopHook(ctx context.Context, hook Hook) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- hook.Stop(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
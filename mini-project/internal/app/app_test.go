@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder records the order in which hooks start and stop
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) contains(event string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Contains(r.events, event)
+}
+
+func (r *recorder) hook(name string, startErr error) Hook {
+	return Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			r.record("start " + name)
+			return startErr
+		},
+		Stop: func(context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+	}
+}
+
+func TestShutdownStopsHooksInReverseOrder(t *testing.T) {
+	var r recorder
+	a := New(time.Second)
+	for _, name := range []string{"postgres", "nats", "workers", "consumer", "http"} {
+		a.Append(r.hook(name, nil))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []string{
+		"start postgres", "start nats", "start workers", "start consumer", "start http",
+		"stop http", "stop consumer", "stop workers", "stop nats", "stop postgres",
+	}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("events = %v, want %v", r.events, want)
+	}
+}
+
+func TestFailedStartOnlyStopsStartedHooks(t *testing.T) {
+	var r recorder
+	startErr := errors.New("address already in use")
+	a := New(time.Second)
+	a.Append(r.hook("postgres", nil))
+	a.Append(r.hook("nats", nil))
+	a.Append(r.hook("http", startErr))
+	a.Append(r.hook("never", nil))
+
+	if err := a.Run(context.Background()); !errors.Is(err, startErr) {
+		t.Fatalf("Run() error = %v, want %v", err, startErr)
+	}
+
+	want := []string{"start postgres", "start nats", "start http", "stop nats", "stop postgres"}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("events = %v, want %v", r.events, want)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	var r recorder
+	a := New(50 * time.Millisecond)
+	a.Append(r.hook("postgres", nil))
+	a.Append(Hook{
+		Name: "workers",
+		Stop: func(ctx context.Context) error {
+			// A worker stuck on a message never returns
+			select {}
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	err := a.Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s, want it bounded by the 50ms deadline", elapsed)
+	}
+
+	// Hooks after the stuck one are still asked to stop, even though nothing waits for them
+	for range 100 {
+		if r.contains("stop postgres") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("events = %v, want postgres to be stopped", r.events)
+}
+
+func TestGoroutineStopWaitsForReturn(t *testing.T) {
+	var r recorder
+	a := New(time.Second)
+	a.Append(Goroutine("consumer", func(ctx context.Context) {
+		<-ctx.Done()
+		// Draining after the stop signal must finish before the next hook stops
+		time.Sleep(10 * time.Millisecond)
+		r.record("consumer drained")
+	}))
+	a.Append(r.hook("http", nil))
+
+	if err := a.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := a.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	want := []string{"start http", "stop http", "consumer drained"}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("events = %v, want %v", r.events, want)
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"nats-project/internal/logging"
+	"net"
+	"net/http"
+)
+
+// HTTPServer listens when the service starts, so a taken port fails the start, and stops
+// accepting requests first on shutdown while letting in-flight requests finish
+func HTTPServer(name string, server *http.Server) Hook {
+	return Hook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+
+			go func() {
+				if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					slog.Error("error serving "+name, logging.KeyError, err)
+				}
+			}()
+			slog.Info(name+" listening", "addr", ln.Addr().String())
+			return nil
+		},
+		Stop: server.Shutdown,
+	}
+}
+
+// Goroutine runs fn in the background from start until stop, stop cancels its context
+// and waits for fn to return
+func Goroutine(name string, fn func(ctx context.Context)) Hook {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+
+	return Hook{
+		Name: name,
+		Start: func(ctx context.Context) error {
+			// The goroutine lives until its hook is stopped, not until the start context ends
+			var runCtx context.Context
+			runCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+			go func() {
+				defer close(done)
+				fn(runCtx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
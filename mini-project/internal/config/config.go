@@ -0,0 +1,530 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultTenant is events.DefaultTenant, which every deployment has without listing it in tenants
+const defaultTenant = "default"
+
+// tenantRegex keeps tenants usable as a subject token and in consumer names
+var tenantRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Config holds the settings shared by all mini-project services. Every field can be set
+// from the YAML file, an environment variable or a command line flag, in increasing priority.
+type Config struct {
+	HTTP        HTTP        `yaml:"http"`
+	Postgres    Postgres    `yaml:"postgres"`
+	NATS        NATS        `yaml:"nats"`
+	Outbox      Outbox      `yaml:"outbox"`
+	Idempotency Idempotency `yaml:"idempotency"`
+	Consumer    Consumer    `yaml:"consumer"`
+	Inventory   Inventory   `yaml:"inventory"`
+	Payment     Payment     `yaml:"payment"`
+	Saga        Saga        `yaml:"saga"`
+	Auth        Auth        `yaml:"auth"`
+	Retry       Retry       `yaml:"retry"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Logging     Logging     `yaml:"logging"`
+	Health      Health      `yaml:"health"`
+	Shutdown    Shutdown    `yaml:"shutdown"`
+
+	// Tenants lists the tenants besides the default tenant. Each gets consumers of its own on the
+	// ORDERS stream, and order-service rejects callers of tenants missing here.
+	Tenants []string `yaml:"tenants" env:"TENANTS" flag:"tenants" usage:"comma separated tenants besides the default tenant"`
+}
+
+type HTTP struct {
+	Addr string `yaml:"addr" env:"HTTP_ADDR" flag:"http-addr" usage:"HTTP listen address"`
+	// MaxStreams caps the SSE and WebSocket order event streams open at the same time,
+	// every stream holds a connection and an ordered JetStream consumer
+	MaxStreams int `yaml:"max_streams" env:"HTTP_MAX_STREAMS" flag:"http-max-streams" usage:"maximum number of concurrent order event streams"`
+	// TrustRequestID reuses the X-Request-ID sent by callers, disable it when clients are not trusted to pick unique ids
+	TrustRequestID bool `yaml:"trust_request_id" env:"HTTP_TRUST_REQUEST_ID" flag:"http-trust-request-id" usage:"reuse the X-Request-ID header of requests"`
+	AccessLog      bool `yaml:"access_log" env:"HTTP_ACCESS_LOG" flag:"http-access-log" usage:"log every completed request"`
+	// TrustedProxies are the proxies whose X-Forwarded-For header gives the client IP used for rate limiting
+	TrustedProxies []string      `yaml:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES" flag:"http-trusted-proxies" usage:"comma separated IPs or CIDRs of trusted reverse proxies"`
+	MaxBodyBytes   int64         `yaml:"max_body_bytes" env:"HTTP_MAX_BODY_BYTES" flag:"http-max-body-bytes" usage:"maximum request body size in bytes, 0 disables the limit"`
+	Timeout        time.Duration `yaml:"timeout" env:"HTTP_TIMEOUT" flag:"http-timeout" usage:"deadline of a request, 0 disables it"`
+	// RouteTimeouts overrides Timeout per route, keyed by method and route like "GET /order/:id".
+	// It can only be set from the config file, and routes missing from it keep their default.
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts"`
+	RateLimit     RateLimit                `yaml:"rate_limit"`
+	CORS          CORS                     `yaml:"cors"`
+}
+
+// inheritRouteTimeouts adds the default route timeouts the config file left out, the file
+// replaces the whole map and would otherwise put the event streams under Timeout
+func (h *HTTP) inheritRouteTimeouts(defaults map[string]time.Duration) {
+	if h.RouteTimeouts == nil {
+		h.RouteTimeouts = map[string]time.Duration{}
+	}
+	for route, timeout := range defaults {
+		if _, ok := h.RouteTimeouts[route]; !ok {
+			h.RouteTimeouts[route] = timeout
+		}
+	}
+}
+
+// RateLimit configures a token bucket per client IP, refilled with RPS tokens a second up to Burst
+type RateLimit struct {
+	RPS   float64 `yaml:"rps" env:"HTTP_RATE_LIMIT_RPS" flag:"http-rate-limit-rps" usage:"requests per second allowed per client, 0 disables rate limiting"`
+	Burst int     `yaml:"burst" env:"HTTP_RATE_LIMIT_BURST" flag:"http-rate-limit-burst" usage:"requests a client may send at once"`
+}
+
+// CORS configures which browser origins may call the HTTP API, none are allowed by default
+type CORS struct {
+	AllowedOrigins []string      `yaml:"allowed_origins" env:"HTTP_CORS_ALLOWED_ORIGINS" flag:"http-cors-allowed-origins" usage:"comma separated origins allowed to call the API, * allows any"`
+	MaxAge         time.Duration `yaml:"max_age" env:"HTTP_CORS_MAX_AGE" flag:"http-cors-max-age" usage:"how long browsers may cache a preflight response"`
+}
+
+type Postgres struct {
+	DSN               string        `yaml:"dsn" env:"POSTGRES_DSN" flag:"postgres-dsn" usage:"postgres connection string"`
+	MaxConns          int32         `yaml:"max_conns" env:"POSTGRES_MAX_CONNS" flag:"postgres-max-conns" usage:"maximum postgres pool size"`
+	MinConns          int32         `yaml:"min_conns" env:"POSTGRES_MIN_CONNS" flag:"postgres-min-conns" usage:"minimum postgres pool size"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period" env:"POSTGRES_HEALTH_CHECK_PERIOD" flag:"postgres-health-check-period" usage:"postgres pool health check period"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time" env:"POSTGRES_MAX_CONN_IDLE_TIME" flag:"postgres-max-conn-idle-time" usage:"maximum idle time of a postgres connection"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime" env:"POSTGRES_MAX_CONN_LIFETIME" flag:"postgres-max-conn-lifetime" usage:"maximum lifetime of a postgres connection"`
+	LazyConnect       bool          `yaml:"lazy_connect" env:"POSTGRES_LAZY_CONNECT" flag:"postgres-lazy-connect" usage:"connect to postgres on first use"`
+}
+
+type NATS struct {
+	URLs          []string      `yaml:"urls" env:"NATS_URLS" flag:"nats-urls" usage:"comma separated NATS server URLs"`
+	MaxReconnects int           `yaml:"max_reconnects" env:"NATS_MAX_RECONNECTS" flag:"nats-max-reconnects" usage:"maximum NATS reconnect attempts, -1 for unlimited"`
+	ReconnectWait time.Duration `yaml:"reconnect_wait" env:"NATS_RECONNECT_WAIT" flag:"nats-reconnect-wait" usage:"wait between NATS reconnect attempts"`
+}
+
+type Outbox struct {
+	Interval  time.Duration `yaml:"interval" env:"OUTBOX_INTERVAL" flag:"outbox-interval" usage:"outbox relay polling interval"`
+	BatchSize int           `yaml:"batch_size" env:"OUTBOX_BATCH_SIZE" flag:"outbox-batch-size" usage:"outbox rows published per relay run"`
+}
+
+// Idempotency configures how long order-service keeps the responses of idempotency keys
+type Idempotency struct {
+	TTL             time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" flag:"idempotency-ttl" usage:"how long the response of an idempotency key is replayed"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" env:"IDEMPOTENCY_CLEANUP_INTERVAL" flag:"idempotency-cleanup-interval" usage:"interval between deletions of expired idempotency keys"`
+}
+
+// Consumer modes
+const (
+	ConsumerModeMessage = "message"
+	ConsumerModeBatch   = "batch"
+)
+
+// Consumer configures the worker pool of each tenant's order consumer. The number of workers and the
+// pull batch size are adjusted between the bounds below every AdjustInterval, and never exceed the
+// consumer's MaxAckPending.
+type Consumer struct {
+	Mode           string        `yaml:"mode" env:"CONSUMER_MODE" flag:"consumer-mode" usage:"message handles each message on its own, batch commits whole fetches in one transaction"`
+	Workers        int           `yaml:"workers" env:"CONSUMER_WORKERS" flag:"consumer-workers" usage:"initial number of consumer workers"`
+	MinWorkers     int           `yaml:"min_workers" env:"CONSUMER_MIN_WORKERS" flag:"consumer-min-workers" usage:"minimum number of consumer workers"`
+	MaxWorkers     int           `yaml:"max_workers" env:"CONSUMER_MAX_WORKERS" flag:"consumer-max-workers" usage:"maximum number of consumer workers"`
+	MaxBatch       int           `yaml:"max_batch" env:"CONSUMER_MAX_BATCH" flag:"consumer-max-batch" usage:"maximum number of messages pulled per fetch"`
+	AdjustInterval time.Duration `yaml:"adjust_interval" env:"CONSUMER_ADJUST_INTERVAL" flag:"consumer-adjust-interval" usage:"interval between worker pool adjustments"`
+	TargetLatency  time.Duration `yaml:"target_latency" env:"CONSUMER_TARGET_LATENCY" flag:"consumer-target-latency" usage:"processing latency above which the worker pool shrinks"`
+	QueueSize      int           `yaml:"queue_size" env:"CONSUMER_QUEUE_SIZE" flag:"consumer-queue-size" usage:"size of the consumer worker queue"`
+	// MetricsAddr is the listen address of the consumer's /metrics, /livez and /readyz endpoints,
+	// the consumer has no other HTTP server
+	MetricsAddr string `yaml:"metrics_addr" env:"CONSUMER_METRICS_ADDR" flag:"consumer-metrics-addr" usage:"consumer metrics and health checks listen address"`
+}
+
+// Inventory configures the inventory service, which reserves one unit of the ordered item per order
+type Inventory struct {
+	InitialStock int    `yaml:"initial_stock" env:"INVENTORY_INITIAL_STOCK" flag:"inventory-initial-stock" usage:"stock of an item the first time it is reserved"`
+	MetricsAddr  string `yaml:"metrics_addr" env:"INVENTORY_METRICS_ADDR" flag:"inventory-metrics-addr" usage:"inventory metrics and health checks listen address"`
+}
+
+// Payment configures the payment service, which declines charges above MaxAmount
+type Payment struct {
+	MaxAmount   float64 `yaml:"max_amount" env:"PAYMENT_MAX_AMOUNT" flag:"payment-max-amount" usage:"order amount above which charges are declined"`
+	MetricsAddr string  `yaml:"metrics_addr" env:"PAYMENT_METRICS_ADDR" flag:"payment-metrics-addr" usage:"payment metrics and health checks listen address"`
+}
+
+// Saga configures the coordinator of the order fulfillment saga
+type Saga struct {
+	MetricsAddr string `yaml:"metrics_addr" env:"SAGA_METRICS_ADDR" flag:"saga-metrics-addr" usage:"saga coordinator metrics and health checks listen address"`
+}
+
+// Auth configures how order-service authenticates its callers. Every caller acts for one
+// tenant and only sees the orders of that tenant. At least one method must be configured.
+type Auth struct {
+	// APIKeys can only be set from the config file
+	APIKeys []APIKey `yaml:"api_keys"`
+	JWT     JWT      `yaml:"jwt"`
+	// Anonymous lets requests without credentials act for the default tenant, for local development
+	Anonymous bool `yaml:"anonymous" env:"AUTH_ANONYMOUS" flag:"auth-anonymous" usage:"accept requests without credentials as the default tenant"`
+}
+
+// APIKey is a key accepted in the X-API-Key header. Only its SHA-256 is configured,
+// so the config file does not hold the key itself.
+type APIKey struct {
+	SHA256  string `yaml:"sha256"`
+	Tenant  string `yaml:"tenant"`
+	Subject string `yaml:"subject"`
+}
+
+// JWT configures the bearer tokens accepted in the Authorization header. HS256 tokens are
+// verified with Secret or an oct key of the JWKS file, RS256 tokens with its RSA keys.
+type JWT struct {
+	Secret      string        `yaml:"secret" env:"JWT_SECRET" flag:"jwt-secret" usage:"secret of HS256 signed tokens"`
+	JWKSFile    string        `yaml:"jwks_file" env:"JWT_JWKS_FILE" flag:"jwt-jwks-file" usage:"JWKS file with the keys of signed tokens"`
+	Issuer      string        `yaml:"issuer" env:"JWT_ISSUER" flag:"jwt-issuer" usage:"required iss claim of tokens, empty accepts any issuer"`
+	Audience    string        `yaml:"audience" env:"JWT_AUDIENCE" flag:"jwt-audience" usage:"required aud claim of tokens, empty accepts any audience"`
+	TenantClaim string        `yaml:"tenant_claim" env:"JWT_TENANT_CLAIM" flag:"jwt-tenant-claim" usage:"claim holding the tenant of the caller"`
+	Leeway      time.Duration `yaml:"leeway" env:"JWT_LEEWAY" flag:"jwt-leeway" usage:"clock skew allowed when checking exp and nbf"`
+}
+
+// Retry configures how failed messages are redelivered
+type Retry struct {
+	Default RetryPolicy `yaml:"default"`
+	// Subjects overrides the default policy per subject or subject pattern, it can only be
+	// set from the config file and fields left unset inherit the default policy
+	Subjects map[string]RetryPolicy `yaml:"subjects"`
+}
+
+// inheritDefaults fills the unset fields of the per subject policies from the default policy
+func (r *Retry) inheritDefaults() {
+	for subject, p := range r.Subjects {
+		if p.InitialDelay == 0 {
+			p.InitialDelay = r.Default.InitialDelay
+		}
+		if p.MaxDelay == 0 {
+			p.MaxDelay = r.Default.MaxDelay
+		}
+		if p.Multiplier == 0 {
+			p.Multiplier = r.Default.Multiplier
+		}
+		if p.Jitter == 0 {
+			p.Jitter = r.Default.Jitter
+		}
+		if p.MaxDeliver == 0 {
+			p.MaxDeliver = r.Default.MaxDeliver
+		}
+		r.Subjects[subject] = p
+	}
+}
+
+// Tracing configures where the OpenTelemetry spans of a service are exported
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"trace exporter: none, otlp or file"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" flag:"tracing-endpoint" usage:"OTLP/HTTP endpoint URL, defaults to OTEL_EXPORTER_OTLP_ENDPOINT"`
+	File        string  `yaml:"file" env:"TRACING_FILE" flag:"tracing-file" usage:"file the spans are appended to by the file exporter"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" usage:"fraction of new traces that are sampled"`
+}
+
+type Logging struct {
+	Level string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"minimum log level: debug, info, warn or error"`
+}
+
+type Health struct {
+	Timeout time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" usage:"timeout of each liveness and readiness check"`
+}
+
+type Shutdown struct {
+	Timeout time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"deadline for stopping the service after SIGINT or SIGTERM"`
+}
+
+type RetryPolicy struct {
+	InitialDelay time.Duration `yaml:"initial_delay" env:"RETRY_INITIAL_DELAY" flag:"retry-initial-delay" usage:"delay before the first redelivery"`
+	MaxDelay     time.Duration `yaml:"max_delay" env:"RETRY_MAX_DELAY" flag:"retry-max-delay" usage:"maximum delay between redeliveries"`
+	Multiplier   float64       `yaml:"multiplier" env:"RETRY_MULTIPLIER" flag:"retry-multiplier" usage:"growth factor of the redelivery delay"`
+	Jitter       float64       `yaml:"jitter" env:"RETRY_JITTER" flag:"retry-jitter" usage:"random fraction added to or removed from each delay"`
+	MaxDeliver   int           `yaml:"max_deliver" env:"RETRY_MAX_DELIVER" flag:"retry-max-deliver" usage:"deliveries before a message is dead-lettered"`
+}
+
+// Default returns the configuration used for local development with deploy/docker-compose.yml
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:           ":8080",
+			MaxStreams:     100,
+			TrustRequestID: true,
+			AccessLog:      true,
+			MaxBodyBytes:   1 << 20,
+			Timeout:        10 * time.Second,
+			// Event streams stay open until their order is finished
+			RouteTimeouts: map[string]time.Duration{
+				"GET /order/:id/events":    0,
+				"GET /order/:id/events/ws": 0,
+			},
+			RateLimit: RateLimit{
+				RPS:   50,
+				Burst: 100,
+			},
+			CORS: CORS{
+				MaxAge: 10 * time.Minute,
+			},
+		},
+		Postgres: Postgres{
+			DSN:               "host=localhost port=5432 user=postgres password=postgres dbname=orders_db sslmode=disable",
+			MaxConns:          5,
+			MinConns:          3,
+			HealthCheckPeriod: 3 * time.Minute,
+			MaxConnIdleTime:   1 * time.Minute,
+			MaxConnLifetime:   3 * time.Minute,
+			LazyConnect:       false,
+		},
+		NATS: NATS{
+			URLs:          []string{"nats://localhost:4222", "nats://localhost:4223", "nats://localhost:4224"},
+			MaxReconnects: 3,
+			ReconnectWait: 2 * time.Second,
+		},
+		Outbox: Outbox{
+			Interval:  1 * time.Second,
+			BatchSize: 100,
+		},
+		Idempotency: Idempotency{
+			TTL:             24 * time.Hour,
+			CleanupInterval: 10 * time.Minute,
+		},
+		Consumer: Consumer{
+			Mode:           ConsumerModeMessage,
+			Workers:        5,
+			MinWorkers:     1,
+			MaxWorkers:     20,
+			MaxBatch:       50,
+			AdjustInterval: 5 * time.Second,
+			TargetLatency:  500 * time.Millisecond,
+			QueueSize:      100,
+			MetricsAddr:    ":9091",
+		},
+		Inventory: Inventory{
+			InitialStock: 100,
+			MetricsAddr:  ":9092",
+		},
+		Payment: Payment{
+			MaxAmount:   500,
+			MetricsAddr: ":9093",
+		},
+		Saga: Saga{
+			MetricsAddr: ":9094",
+		},
+		Auth: Auth{
+			JWT: JWT{
+				TenantClaim: "tenant_id",
+				Leeway:      30 * time.Second,
+			},
+		},
+		Retry: Retry{
+			Default: RetryPolicy{
+				InitialDelay: 5 * time.Second,
+				MaxDelay:     1 * time.Minute,
+				Multiplier:   2,
+				Jitter:       0.2,
+				MaxDeliver:   5,
+			},
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			SampleRatio: 1,
+		},
+		Logging: Logging{
+			Level: "info",
+		},
+		Health: Health{
+			Timeout: 2 * time.Second,
+		},
+		Shutdown: Shutdown{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Validate checks that the configuration is usable
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.HTTP.Addr == "" {
+		errs = append(errs, errors.New("http.addr is required"))
+	}
+	if c.HTTP.MaxStreams < 1 {
+		errs = append(errs, errors.New("http.max_streams must be at least 1"))
+	}
+	if c.HTTP.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("http.max_body_bytes must not be negative"))
+	}
+	if c.HTTP.Timeout < 0 {
+		errs = append(errs, errors.New("http.timeout must not be negative"))
+	}
+	for route, timeout := range c.HTTP.RouteTimeouts {
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("http.route_timeouts: route %q must be a method and a path like \"GET /order/:id\"", route))
+		}
+		if timeout < 0 {
+			errs = append(errs, fmt.Errorf("http.route_timeouts: timeout of %q must not be negative", route))
+		}
+	}
+	if c.HTTP.RateLimit.RPS < 0 {
+		errs = append(errs, errors.New("http.rate_limit.rps must not be negative"))
+	}
+	if c.HTTP.RateLimit.RPS > 0 && c.HTTP.RateLimit.Burst < 1 {
+		errs = append(errs, errors.New("http.rate_limit.burst must be at least 1"))
+	}
+	if c.HTTP.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("http.cors.max_age must not be negative"))
+	}
+
+	if c.Postgres.DSN == "" {
+		errs = append(errs, errors.New("postgres.dsn is required"))
+	}
+	if c.Postgres.MaxConns < 1 {
+		errs = append(errs, errors.New("postgres.max_conns must be at least 1"))
+	}
+	if c.Postgres.MinConns < 0 || c.Postgres.MinConns > c.Postgres.MaxConns {
+		errs = append(errs, fmt.Errorf("postgres.min_conns must be between 0 and max_conns (%d)", c.Postgres.MaxConns))
+	}
+
+	if len(c.NATS.URLs) == 0 {
+		errs = append(errs, errors.New("nats.urls is required"))
+	}
+	if c.NATS.MaxReconnects < -1 {
+		errs = append(errs, errors.New("nats.max_reconnects must be -1 or more"))
+	}
+
+	if c.Outbox.Interval <= 0 {
+		errs = append(errs, errors.New("outbox.interval must be positive"))
+	}
+	if c.Outbox.BatchSize < 1 {
+		errs = append(errs, errors.New("outbox.batch_size must be at least 1"))
+	}
+
+	if c.Idempotency.TTL <= 0 {
+		errs = append(errs, errors.New("idempotency.ttl must be positive"))
+	}
+	if c.Idempotency.CleanupInterval <= 0 {
+		errs = append(errs, errors.New("idempotency.cleanup_interval must be positive"))
+	}
+
+	if c.Consumer.Mode != ConsumerModeMessage && c.Consumer.Mode != ConsumerModeBatch {
+		errs = append(errs, fmt.Errorf("consumer.mode must be %s or %s, got %q", ConsumerModeMessage, ConsumerModeBatch, c.Consumer.Mode))
+	}
+	if c.Consumer.MinWorkers < 1 {
+		errs = append(errs, errors.New("consumer.min_workers must be at least 1"))
+	}
+	if c.Consumer.MaxWorkers < c.Consumer.MinWorkers {
+		errs = append(errs, errors.New("consumer.max_workers must not be less than min_workers"))
+	}
+	if c.Consumer.Workers < c.Consumer.MinWorkers || c.Consumer.Workers > c.Consumer.MaxWorkers {
+		errs = append(errs, fmt.Errorf("consumer.workers must be between min_workers (%d) and max_workers (%d)",
+			c.Consumer.MinWorkers, c.Consumer.MaxWorkers))
+	}
+	if c.Consumer.MaxBatch < 1 {
+		errs = append(errs, errors.New("consumer.max_batch must be at least 1"))
+	}
+	if c.Consumer.AdjustInterval <= 0 {
+		errs = append(errs, errors.New("consumer.adjust_interval must be positive"))
+	}
+	if c.Consumer.TargetLatency <= 0 {
+		errs = append(errs, errors.New("consumer.target_latency must be positive"))
+	}
+	if c.Consumer.QueueSize < 0 {
+		errs = append(errs, errors.New("consumer.queue_size must not be negative"))
+	}
+	if c.Consumer.MetricsAddr == "" {
+		errs = append(errs, errors.New("consumer.metrics_addr is required"))
+	}
+
+	if c.Inventory.InitialStock < 0 {
+		errs = append(errs, errors.New("inventory.initial_stock must not be negative"))
+	}
+	if c.Inventory.MetricsAddr == "" {
+		errs = append(errs, errors.New("inventory.metrics_addr is required"))
+	}
+	if c.Payment.MaxAmount < 0 {
+		errs = append(errs, errors.New("payment.max_amount must not be negative"))
+	}
+	if c.Payment.MetricsAddr == "" {
+		errs = append(errs, errors.New("payment.metrics_addr is required"))
+	}
+	if c.Saga.MetricsAddr == "" {
+		errs = append(errs, errors.New("saga.metrics_addr is required"))
+	}
+
+	tenants := map[string]bool{defaultTenant: true}
+	for _, tenant := range c.Tenants {
+		if !tenantRegex.MatchString(tenant) {
+			errs = append(errs, fmt.Errorf("tenants: %q must be 1 to 64 letters, digits, _ or -", tenant))
+		}
+		if tenants[tenant] {
+			errs = append(errs, fmt.Errorf("tenants: %q is listed twice or is the default tenant", tenant))
+		}
+		tenants[tenant] = true
+	}
+
+	for i, key := range c.Auth.APIKeys {
+		if len(key.SHA256) != 64 {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d].sha256 must be a hex encoded SHA-256", i))
+		}
+		if key.Tenant == "" {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d].tenant is required", i))
+		} else if !tenants[key.Tenant] {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d].tenant %q is not listed in tenants", i, key.Tenant))
+		}
+	}
+	if c.Auth.JWT.TenantClaim == "" {
+		errs = append(errs, errors.New("auth.jwt.tenant_claim is required"))
+	}
+	if c.Auth.JWT.Leeway < 0 {
+		errs = append(errs, errors.New("auth.jwt.leeway must not be negative"))
+	}
+
+	errs = append(errs, c.Retry.Default.validate("retry.default"))
+	for subject, policy := range c.Retry.Subjects {
+		errs = append(errs, policy.validate("retry.subjects."+subject))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp":
+	case "file":
+		if c.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing.file is required by the file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, otlp or file, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level))
+	}
+
+	if c.Health.Timeout <= 0 {
+		errs = append(errs, errors.New("health.timeout must be positive"))
+	}
+	if c.Shutdown.Timeout <= 0 {
+		errs = append(errs, errors.New("shutdown.timeout must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p RetryPolicy) validate(name string) error {
+	var errs []error
+
+	if p.InitialDelay <= 0 {
+		errs = append(errs, fmt.Errorf("%s.initial_delay must be positive", name))
+	}
+	if p.MaxDelay < p.InitialDelay {
+		errs = append(errs, fmt.Errorf("%s.max_delay must not be less than initial_delay", name))
+	}
+	if p.Multiplier < 1 {
+		errs = append(errs, fmt.Errorf("%s.multiplier must be at least 1", name))
+	}
+	if p.Jitter < 0 || p.Jitter >= 1 {
+		errs = append(errs, fmt.Errorf("%s.jitter must be between 0 and 1", name))
+	}
+	if p.MaxDeliver < 1 {
+		errs = append(errs, fmt.Errorf("%s.max_deliver must be at least 1", name))
+	}
+
+	return errors.Join(errs...)
+}
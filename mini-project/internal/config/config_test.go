@@ -0,0 +1,183 @@
+package config
+
+import (
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	const file = `
+http:
+  addr: ":8081"
+outbox:
+  batch_size: 10
+tenants: [acme]
+`
+
+	tests := []struct {
+		name       string
+		file       string
+		env        map[string]string
+		args       []string
+		wantAddr   string
+		wantBatch  int
+		wantTenant []string
+	}{
+		{name: "defaults", wantAddr: ":8080", wantBatch: 100},
+		{name: "file over defaults", file: file, wantAddr: ":8081", wantBatch: 10, wantTenant: []string{"acme"}},
+		{
+			name:       "env over file",
+			file:       file,
+			env:        map[string]string{"HTTP_ADDR": ":8082", "TENANTS": "globex, initech"},
+			wantAddr:   ":8082",
+			wantBatch:  10,
+			wantTenant: []string{"globex", "initech"},
+		},
+		{
+			name:       "flags over env",
+			file:       file,
+			env:        map[string]string{"HTTP_ADDR": ":8082", "OUTBOX_BATCH_SIZE": "20"},
+			args:       []string{"-http-addr", ":8083"},
+			wantAddr:   ":8083",
+			wantBatch:  20,
+			wantTenant: []string{"acme"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setenv restores the variables after the test, they are unset so only tt.env applies
+			t.Setenv(configFileEnv, "")
+			for _, env := range []string{"HTTP_ADDR", "OUTBOX_BATCH_SIZE", "TENANTS"} {
+				t.Setenv(env, "")
+				os.Unsetenv(env)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+
+			cfg, err := LoadFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.HTTP.Addr != tt.wantAddr {
+				t.Errorf("http.addr = %q, want %q", cfg.HTTP.Addr, tt.wantAddr)
+			}
+			if cfg.Outbox.BatchSize != tt.wantBatch {
+				t.Errorf("outbox.batch_size = %d, want %d", cfg.Outbox.BatchSize, tt.wantBatch)
+			}
+			if strings.Join(cfg.Tenants, ",") != strings.Join(tt.wantTenant, ",") {
+				t.Errorf("tenants = %v, want %v", cfg.Tenants, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Setenv(configFileEnv, "")
+	t.Setenv("OUTBOX_BATCH_SIZE", "many")
+
+	if _, err := LoadFlagSet(flag.NewFlagSet("test", flag.ContinueOnError), nil); err == nil {
+		t.Fatal("LoadFlagSet accepted an invalid env var")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{name: "defaults", change: func(c *Config) {}},
+		{name: "tenants", change: func(c *Config) { c.Tenants = []string{"acme", "globex"} }},
+		{name: "missing addr", change: func(c *Config) { c.HTTP.Addr = "" }, wantErr: "http.addr is required"},
+		{name: "route without method", change: func(c *Config) { c.HTTP.RouteTimeouts["/order"] = time.Second }, wantErr: "http.route_timeouts"},
+		{name: "negative route timeout", change: func(c *Config) { c.HTTP.RouteTimeouts["GET /orders"] = -time.Second }, wantErr: "must not be negative"},
+		{name: "rate limit without burst", change: func(c *Config) { c.HTTP.RateLimit.Burst = 0 }, wantErr: "http.rate_limit.burst"},
+		{name: "unknown consumer mode", change: func(c *Config) { c.Consumer.Mode = "stream" }, wantErr: "consumer.mode"},
+		{name: "workers above max", change: func(c *Config) { c.Consumer.Workers = c.Consumer.MaxWorkers + 1 }, wantErr: "consumer.workers"},
+		{name: "idempotency ttl", change: func(c *Config) { c.Idempotency.TTL = 0 }, wantErr: "idempotency.ttl"},
+		{name: "tenant with dot", change: func(c *Config) { c.Tenants = []string{"acme.eu"} }, wantErr: "tenants"},
+		{name: "default tenant listed", change: func(c *Config) { c.Tenants = []string{defaultTenant} }, wantErr: "listed twice"},
+		{
+			name:    "api key of unknown tenant",
+			change:  func(c *Config) { c.Auth.APIKeys = []APIKey{{SHA256: strings.Repeat("a", 64), Tenant: "acme"}} },
+			wantErr: "not listed in tenants",
+		},
+		{name: "retry delays", change: func(c *Config) { c.Retry.Default.MaxDelay = time.Second }, wantErr: "retry.default.max_delay"},
+		{
+			name:    "subject retry policy",
+			change:  func(c *Config) { c.Retry.Subjects = map[string]RetryPolicy{"orders.*": {MaxDeliver: 1}} },
+			wantErr: "retry.subjects.orders.*.initial_delay",
+		},
+		{name: "tracing exporter", change: func(c *Config) { c.Tracing.Exporter = "jaeger" }, wantErr: "tracing.exporter"},
+		{name: "log level", change: func(c *Config) { c.Logging.Level = "verbose" }, wantErr: "logging.level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(&cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInheritRouteTimeouts(t *testing.T) {
+	defaults := map[string]time.Duration{
+		"GET /order/:id/events":    0,
+		"GET /order/:id/events/ws": 0,
+	}
+
+	tests := []struct {
+		name   string
+		routes map[string]time.Duration
+		want   map[string]time.Duration
+	}{
+		{name: "unset", want: defaults},
+		{
+			name:   "file adds a route",
+			routes: map[string]time.Duration{"GET /orders": time.Minute},
+			want:   map[string]time.Duration{"GET /orders": time.Minute, "GET /order/:id/events": 0, "GET /order/:id/events/ws": 0},
+		},
+		{
+			name:   "file overrides a default",
+			routes: map[string]time.Duration{"GET /order/:id/events": time.Hour},
+			want:   map[string]time.Duration{"GET /order/:id/events": time.Hour, "GET /order/:id/events/ws": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := HTTP{RouteTimeouts: tt.routes}
+			h.inheritRouteTimeouts(defaults)
+			if !maps.Equal(h.RouteTimeouts, tt.want) {
+				t.Errorf("route timeouts = %v, want %v", h.RouteTimeouts, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+const configFileEnv = "CONFIG_FILE"
+
+// field is a leaf setting of Config together with its env and flag names
+type field struct {
+	env   string
+	flag  string
+	usage string
+	value reflect.Value
+}
+
+// Load builds the configuration from the defaults, the optional YAML file given by
+// -config or CONFIG_FILE, environment variables and finally the command line flags
+func Load(args []string) (Config, error) {
+	return LoadFlagSet(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), args)
+}
+
+// LoadFlagSet is like Load but parses args with fs, so commands can register their own
+// flags before loading and read positional arguments from fs.Args afterwards
+func LoadFlagSet(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Default()
+	fields := collectFields(reflect.ValueOf(&cfg).Elem())
+
+	configFile := fs.String("config", os.Getenv(configFileEnv), "path to a YAML config file")
+
+	// Flags are only recorded while parsing, they are applied last so they win over the file and env
+	flagValues := map[string]string{}
+	for _, f := range fields {
+		fs.Func(f.flag, f.usage, func(v string) error {
+			flagValues[f.flag] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parsing config file %s: %w", *configFile, err)
+		}
+	}
+
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := setValue(f.value, v); err != nil {
+				return Config{}, fmt.Errorf("invalid %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if v, ok := flagValues[f.flag]; ok {
+			if err := setValue(f.value, v); err != nil {
+				return Config{}, fmt.Errorf("invalid -%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	cfg.Retry.inheritDefaults()
+	cfg.HTTP.inheritRouteTimeouts(Default().HTTP.RouteTimeouts)
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// collectFields walks the nested config structs and returns every tagged leaf field
+func collectFields(v reflect.Value) []field {
+	var fields []field
+	for i := range v.NumField() {
+		sf := v.Type().Field(i)
+		fv := v.Field(i)
+
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectFields(fv)...)
+			continue
+		}
+
+		if sf.Tag.Get("env") == "" {
+			continue
+		}
+		fields = append(fields, field{
+			env:   sf.Tag.Get("env"),
+			flag:  sf.Tag.Get("flag"),
+			usage: sf.Tag.Get("usage"),
+			value: fv,
+		})
+	}
+
+	return fields
+}
+
+// setValue parses a string from an env var or flag into the field
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %s", v.Type())
+		}
+		var items []string
+		for item := range strings.SplitSeq(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+
+	return nil
+}
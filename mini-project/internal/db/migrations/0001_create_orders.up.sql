@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS orders (
+	id TEXT PRIMARY KEY,
+	item TEXT NOT NULL,
+	amount DOUBLE PRECISION NOT NULL,
+	status TEXT NOT NULL
+);
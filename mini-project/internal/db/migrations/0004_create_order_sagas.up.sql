@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS order_sagas (
+	order_id TEXT PRIMARY KEY REFERENCES orders (id),
+	item TEXT NOT NULL,
+	amount DOUBLE PRECISION NOT NULL,
+	state TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS inventory (
+	item TEXT PRIMARY KEY,
+	available INTEGER NOT NULL CHECK (available >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS inventory_reservations (
+	order_id TEXT PRIMARY KEY,
+	item TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS payments (
+	order_id TEXT PRIMARY KEY,
+	amount DOUBLE PRECISION NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+-- Bumped with every status change, history events carry it so the projector keeps the latest state
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
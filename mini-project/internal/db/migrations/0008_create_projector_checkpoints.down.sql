@@ -0,0 +1,3 @@
+ALTER TABLE order_sagas ADD CONSTRAINT order_sagas_order_id_fkey FOREIGN KEY (order_id) REFERENCES orders (id);
+
+DROP TABLE IF EXISTS projector_checkpoints;
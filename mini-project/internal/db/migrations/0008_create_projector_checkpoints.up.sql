@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS projector_checkpoints (
+	name TEXT PRIMARY KEY,
+	stream_seq BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- The projector swaps a rebuilt orders table in, a foreign key would keep pointing at the replaced one
+ALTER TABLE order_sagas DROP CONSTRAINT IF EXISTS order_sagas_order_id_fkey;
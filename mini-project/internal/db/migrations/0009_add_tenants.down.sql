@@ -0,0 +1,9 @@
+-- Keys reused across tenants cannot share the old primary key, the oldest one is kept
+DELETE FROM idempotency_keys k USING idempotency_keys o
+	WHERE k.key = o.key AND (k.created_at, k.tenant_id) > (o.created_at, o.tenant_id);
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
+
+DROP INDEX IF EXISTS orders_tenant_id_id_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,8 @@
+-- Orders created before tenants existed belong to the default tenant
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS orders_tenant_id_id_idx ON orders (tenant_id, id);
+
+-- Clients pick their idempotency keys, so two tenants may use the same one
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (tenant_id, key);
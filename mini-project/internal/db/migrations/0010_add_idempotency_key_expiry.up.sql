@@ -0,0 +1,5 @@
+-- Stored responses expire, keys older than that may be reused and are deleted by order-service
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+UPDATE idempotency_keys SET expires_at = created_at + interval '24 hours' WHERE expires_at IS NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN expires_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -2,56 +2,52 @@ package db
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
 	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-const (
-	maxConn           = 5
-	minConns          = 3
-	healthCheckPeriod = 3 * time.Minute
-	maxConnIdleTime   = 1 * time.Minute
-	maxConnLifetime   = 3 * time.Minute
-	lazyConnect       = false
-)
-
-func InitPostgresDB(ctx context.Context) (*pgxpool.Pool, error) {
+func InitPostgresDB(ctx context.Context, cfg config.Postgres) (*pgxpool.Pool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	dataSourceName := "host=localhost port=5432 user=postgres password=postgres dbname=orders_db sslmode=disable"
-
-	poolCfg, err := pgxpool.ParseConfig(dataSourceName)
+	poolCfg, err := pgxpool.ParseConfig(cfg.DSN)
 	if err != nil {
-		log.Println("error parsing postgres config", err)
+		slog.Error("error parsing postgres config", logging.KeyError, err)
 		return nil, err
 	}
 
-	poolCfg.MaxConns = maxConn
-	poolCfg.MinConns = minConns
-	poolCfg.HealthCheckPeriod = healthCheckPeriod
-	poolCfg.MaxConnIdleTime = maxConnIdleTime
-	poolCfg.MaxConnLifetime = maxConnLifetime
-	poolCfg.LazyConnect = lazyConnect
+	poolCfg.MaxConns = cfg.MaxConns
+	poolCfg.MinConns = cfg.MinConns
+	poolCfg.HealthCheckPeriod = cfg.HealthCheckPeriod
+	poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	poolCfg.LazyConnect = cfg.LazyConnect
+
+	// Statement timings are read from pgx's info level log events
+	poolCfg.ConnConfig.Logger = queryLogger{}
+	poolCfg.ConnConfig.LogLevel = pgx.LogLevelInfo
 
 	connPool, err := pgxpool.ConnectConfig(ctx, poolCfg)
 	if err != nil {
-		log.Println("error connecting to postgres database", err)
+		slog.Error("error connecting to postgres database", logging.KeyError, err)
 		return nil, err
 	}
 
 	conn, err := connPool.Acquire(ctx)
 	if err != nil {
-		log.Println("error acquiring connection from postgres pool", err)
+		slog.Error("error acquiring connection from postgres pool", logging.KeyError, err)
 		return nil, err
 	}
 	defer conn.Release()
 
 	// Ping the database
 	if err = conn.Conn().Ping(ctx); err != nil {
-		log.Println("error pinging postgres database", err)
+		slog.Error("error pinging postgres database", logging.KeyError, err)
 		return nil, err
 	}
 
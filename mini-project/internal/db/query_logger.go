@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"nats-project/internal/metrics"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "nats-project/internal/db"
+
+// queryLogger receives pgx's statement log events and turns their timings into metrics and spans.
+// pgx v4 has no tracer hooks, but every Query, Exec and SendBatch is logged with its duration.
+type queryLogger struct{}
+
+func (queryLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]any) {
+	duration, ok := data["time"].(time.Duration)
+	if !ok {
+		return
+	}
+
+	sql, _ := data["sql"].(string)
+	err, _ := data["err"].(error)
+	operation := operationOf(msg, sql)
+	metrics.ObserveQuery(operation, duration, err)
+	traceQuery(ctx, operation, sql, duration, err)
+}
+
+// traceQuery records the statement as a span that already finished. Statements outside of
+// a trace, like the outbox relay polling, are skipped so they do not flood the exporter.
+func traceQuery(ctx context.Context, operation, sql string, duration time.Duration, err error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+
+	end := time.Now()
+	_, span := otel.Tracer(tracerName).Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(end.Add(-duration)),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(sql),
+		),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// operationOf returns the SQL command of a statement, e.g. SELECT or UPDATE, keeping the metric labels bounded
+func operationOf(msg, sql string) string {
+	if msg == "SendBatch" {
+		return "BATCH"
+	}
+
+	fields := strings.Fields(sql)
+	if len(fields) == 0 || fields[0] == ";" {
+		return "PING"
+	}
+
+	return strings.ToUpper(strings.TrimSuffix(fields[0], ";"))
+}
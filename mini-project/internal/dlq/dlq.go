@@ -0,0 +1,429 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	ns "nats-project/internal/nats"
+	"nats-project/internal/order"
+	"nats-project/internal/outbox"
+	"nats-project/internal/saga"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+const (
+	StreamName    = "ORDERS_DLQ"
+	SubjectPrefix = "dlq."
+
+	// AdvisorySubject receives an advisory each time a message of the ORDERS stream exhausts MaxDeliver
+	AdvisorySubject = "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.ORDERS.>"
+	// AdvisoryStreamName keeps the advisories until the listener dead-lettered their message
+	AdvisoryStreamName = "ORDERS_DLQ_ADVISORIES"
+	ListenerDurable    = "DLQ_LISTENER"
+
+	// retryDelay is the wait before an advisory that could not be handled is delivered again
+	retryDelay = 10 * time.Second
+)
+
+// StreamConfig returns the stream messages are dead-lettered to, they are kept for two weeks
+func StreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:       StreamName,
+		Subjects:   []string{SubjectPrefix + "orders.>"},
+		Storage:    jetstream.FileStorage,
+		Retention:  jetstream.LimitsPolicy,
+		MaxAge:     14 * 24 * time.Hour,
+		Discard:    jetstream.DiscardOld,
+		Duplicates: 2 * time.Minute,
+	}
+}
+
+// AdvisoryStreamConfig returns the stream capturing the max deliveries advisories of ORDERS.
+// Advisories are plain NATS messages, without the stream they are lost while no listener runs.
+func AdvisoryStreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:      AdvisoryStreamName,
+		Subjects:  []string{AdvisorySubject},
+		Storage:   jetstream.FileStorage,
+		Retention: jetstream.WorkQueuePolicy,
+		MaxAge:    14 * 24 * time.Hour,
+		Discard:   jetstream.DiscardOld,
+	}
+}
+
+// ListenerConsumerConfig returns the durable consumer of the listener. Advisories are retried
+// until their message is dead-lettered, the ack wait covers the handling of one.
+func ListenerConsumerConfig() jetstream.ConsumerConfig {
+	return jetstream.ConsumerConfig{
+		Durable:       ListenerDurable,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       30 * time.Second,
+		MaxAckPending: 20,
+	}
+}
+
+// Failure metadata added to every dead-lettered message
+const (
+	HeaderStream     = "Dlq-Stream"
+	HeaderConsumer   = "Dlq-Consumer"
+	HeaderStreamSeq  = "Dlq-Stream-Seq"
+	HeaderSubject    = "Dlq-Subject"
+	HeaderDeliveries = "Dlq-Deliveries"
+	HeaderFailedAt   = "Dlq-Failed-At"
+)
+
+// advisory is the io.nats.jetstream.advisory.v1.max_deliver payload
+type advisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// ErrNotReplayable is returned by Replay for entries other than order created events. Replaying
+// a saga command or outcome would put the order back to PENDING while its saga runs on.
+var ErrNotReplayable = errors.New("only order created events can be replayed")
+
+// Entry is a message stored in the dead-letter stream
+type Entry struct {
+	Sequence   uint64
+	Subject    string
+	Stream     string
+	Consumer   string
+	StreamSeq  uint64
+	Deliveries uint64
+	FailedAt   time.Time
+	Header     nats.Header
+	Data       []byte
+}
+
+// DLQ moves messages that exhausted their deliveries out of ORDERS and manages them afterwards
+type DLQ struct {
+	pgxPool *pgxpool.Pool
+	js      jetstream.JetStream
+}
+
+func New(pgxPool *pgxpool.Pool, js jetstream.JetStream) *DLQ {
+	return &DLQ{pgxPool: pgxPool, js: js}
+}
+
+// Listen handles the advisories captured in the advisory stream until the context is cancelled.
+// Instances share the durable consumer, so each advisory is handled once. An advisory is only
+// acked once its message is dead-lettered, failures and advisories raised while no listener
+// runs are handled later.
+func (d *DLQ) Listen(ctx context.Context) error {
+	consumer, err := d.js.Consumer(ctx, AdvisoryStreamName, ListenerDurable)
+	if err != nil {
+		return err
+	}
+
+	// Advisories already pulled are finished on shutdown
+	handleCtx := context.WithoutCancel(ctx)
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		d.handleAdvisory(handleCtx, msg)
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("listening for max deliveries advisories", logging.KeyStream, AdvisoryStreamName)
+
+	<-ctx.Done()
+	cc.Drain()
+	<-cc.Closed()
+	return nil
+}
+
+func (d *DLQ) handleAdvisory(ctx context.Context, msg jetstream.Msg) {
+	var adv advisory
+	if err := json.Unmarshal(msg.Data(), &adv); err != nil {
+		slog.Error("error unmarshalling max deliveries advisory", logging.KeyError, err)
+		if err = msg.Term(); err != nil {
+			slog.Error("error terminating advisory", logging.KeyError, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ctx, logger := logging.With(ctx, logging.KeyStream, adv.Stream, logging.KeyStreamSeq, adv.StreamSeq,
+		logging.KeyDelivery, adv.Deliveries, "consumer", adv.Consumer)
+
+	if err := d.deadLetter(ctx, adv); err != nil {
+		logger.Error("error dead-lettering message, retrying", "retry_in", retryDelay, logging.KeyError, err)
+		if err = msg.NakWithDelay(retryDelay); err != nil {
+			logger.Error("error settling advisory", logging.KeyError, err)
+		}
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		logger.Error("error settling advisory", logging.KeyError, err)
+	}
+}
+
+// deadLetter copies the failed message into the DLQ stream, marks its order FAILED and
+// removes it from the work queue, where it would otherwise stay forever
+func (d *DLQ) deadLetter(ctx context.Context, adv advisory) error {
+	stream, err := d.js.Stream(ctx, adv.Stream)
+	if err != nil {
+		return err
+	}
+
+	msg, err := stream.GetMsg(ctx, adv.StreamSeq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		logging.FromContext(ctx).Info("message is gone, it was acked or already dead-lettered")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dlqMsg := &nats.Msg{
+		Subject: SubjectPrefix + msg.Subject,
+		Header:  nats.Header{},
+		Data:    msg.Data,
+	}
+	for k, v := range msg.Header {
+		dlqMsg.Header[k] = v
+	}
+	dlqMsg.Header.Set(HeaderStream, adv.Stream)
+	dlqMsg.Header.Set(HeaderConsumer, adv.Consumer)
+	dlqMsg.Header.Set(HeaderStreamSeq, strconv.FormatUint(adv.StreamSeq, 10))
+	dlqMsg.Header.Set(HeaderSubject, msg.Subject)
+	dlqMsg.Header.Set(HeaderDeliveries, strconv.FormatUint(adv.Deliveries, 10))
+	dlqMsg.Header.Set(HeaderFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	// The message id makes a repeated advisory for the same sequence a no-op
+	msgID := fmt.Sprintf("%s-%d", adv.Stream, adv.StreamSeq)
+	// Dead-lettering continues the trace and request id of the failed message
+	ctx = ns.ExtractTrace(ctx, msg.Header)
+	if id := msg.Header.Get(logging.HeaderRequestID); id != "" {
+		ctx = logging.WithRequestID(ctx, id)
+	}
+	pubCtx, span := ns.StartPublishSpan(ctx, dlqMsg)
+	start := time.Now()
+	_, err = d.js.PublishMsg(pubCtx, dlqMsg, jetstream.WithMsgID(msgID))
+	metrics.ObservePublish(dlqMsg.Subject, time.Since(start), err)
+	ns.EndSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("publishing to %s: %w", StreamName, err)
+	}
+
+	if err = d.markFailed(ctx, msg.Subject, msg.Header, msg.Data); err != nil {
+		return fmt.Errorf("marking order failed: %w", err)
+	}
+
+	if err = stream.DeleteMsg(ctx, adv.StreamSeq); err != nil && !errors.Is(err, jetstream.ErrMsgNotFound) {
+		return fmt.Errorf("deleting %s message %d: %w", adv.Stream, adv.StreamSeq, err)
+	}
+
+	logging.FromContext(ctx).Info("dead-lettered message", logging.KeySubject, msg.Subject)
+	return nil
+}
+
+// markFailed moves the order the message is about to FAILED, unless it already finished,
+// and aborts its saga
+func (d *DLQ) markFailed(ctx context.Context, subject string, header nats.Header, data []byte) error {
+	orderID, err := orderIDOf(subject, header, data)
+	if err != nil {
+		return err
+	}
+	ctx, logger := logging.With(ctx, logging.KeyOrderID, orderID)
+
+	// The status change and its history event are committed together
+	tx, err := d.pgxPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	o, err := order.Get(ctx, tx, "", orderID)
+	if errors.Is(err, order.ErrNotFound) {
+		logger.Warn("dead-lettered message refers to unknown order")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if order.CanTransition(o.Status, order.Failed) {
+		if err = order.Transition(ctx, tx, orderID, o.Status, order.Failed); err != nil {
+			return err
+		}
+	} else {
+		logger.Info("order already finished, leaving its status unchanged", "status", o.Status)
+	}
+
+	// The saga is compensated in the same transaction, so the reserved item is released and a
+	// charge is refunded. A saga that already ended is left alone.
+	reason := fmt.Sprintf("message on %s exhausted its deliveries", subject)
+	if err = saga.Abort(ctx, tx, o.TenantID, orderID, reason); err != nil {
+		return fmt.Errorf("aborting saga: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// List returns up to limit DLQ entries starting at sequence start
+func (d *DLQ) List(ctx context.Context, start uint64, limit int) ([]Entry, error) {
+	stream, err := d.js.Stream(ctx, StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	seq := max(start, 1)
+	for len(entries) < limit {
+		// Asking for the next message on the subject skips sequences that were discarded
+		msg, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(SubjectPrefix+">"))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, toEntry(msg))
+		seq = msg.Sequence + 1
+	}
+
+	return entries, nil
+}
+
+// Inspect returns a single DLQ entry
+func (d *DLQ) Inspect(ctx context.Context, seq uint64) (Entry, error) {
+	stream, err := d.js.Stream(ctx, StreamName)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	msg, err := stream.GetMsg(ctx, seq)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return toEntry(msg), nil
+}
+
+// Replay puts the order of an order created event back to PENDING and re-queues the event
+// through the outbox in one transaction, then removes the entry from the DLQ
+func (d *DLQ) Replay(ctx context.Context, seq uint64) error {
+	entry, err := d.Inspect(ctx, seq)
+	if err != nil {
+		return err
+	}
+
+	env, err := events.Decode(entry.Subject, entry.Header, entry.Data)
+	if err != nil {
+		return err
+	}
+	if env.Type != events.TypeOrderCreated {
+		return fmt.Errorf("%w, entry %d is a %s event", ErrNotReplayable, seq, env.Type)
+	}
+	// A new id keeps the replay from being dropped by the ORDERS duplicate window
+	env.ID = nuid.Next()
+
+	orderID, err := orderIDOf(entry.Subject, entry.Header, entry.Data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.pgxPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = order.Transition(ctx, tx, orderID, order.Failed, order.Pending)
+	var transitionErr *order.TransitionError
+	switch {
+	case errors.As(err, &transitionErr) && transitionErr.Current == order.Pending:
+		// The order was never marked FAILED, it only needs its event again
+	case errors.Is(err, order.ErrNotFound):
+		slog.Warn("replaying DLQ entry for unknown order", logging.KeyOrderID, orderID)
+	case err != nil:
+		return err
+	}
+
+	if err = outbox.Insert(ctx, tx, entry.Subject, env); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	slog.Info("replayed DLQ entry", "dlq_seq", seq, logging.KeySubject, entry.Subject, logging.KeyOrderID, orderID)
+
+	return d.Discard(ctx, seq)
+}
+
+// Discard deletes an entry from the DLQ
+func (d *DLQ) Discard(ctx context.Context, seq uint64) error {
+	stream, err := d.js.Stream(ctx, StreamName)
+	if err != nil {
+		return err
+	}
+
+	return stream.DeleteMsg(ctx, seq)
+}
+
+func toEntry(msg *jetstream.RawStreamMsg) Entry {
+	entry := Entry{
+		Sequence: msg.Sequence,
+		Subject:  msg.Header.Get(HeaderSubject),
+		Stream:   msg.Header.Get(HeaderStream),
+		Consumer: msg.Header.Get(HeaderConsumer),
+		Header:   nats.Header{},
+		Data:     msg.Data,
+	}
+	if entry.Subject == "" {
+		entry.Subject = strings.TrimPrefix(msg.Subject, SubjectPrefix)
+	}
+	entry.StreamSeq, _ = strconv.ParseUint(msg.Header.Get(HeaderStreamSeq), 10, 64)
+	entry.Deliveries, _ = strconv.ParseUint(msg.Header.Get(HeaderDeliveries), 10, 64)
+	entry.FailedAt, _ = time.Parse(time.RFC3339Nano, msg.Header.Get(HeaderFailedAt))
+
+	// Keep only the headers of the original message
+	for k, v := range msg.Header {
+		if !strings.HasPrefix(k, "Dlq-") {
+			entry.Header[k] = v
+		}
+	}
+
+	return entry
+}
+
+// orderIDOf extracts the order id from an order event of any schema version
+func orderIDOf(subject string, header nats.Header, data []byte) (string, error) {
+	env, err := events.Decode(subject, header, data)
+	if err != nil {
+		return "", err
+	}
+	if env.Subject != "" {
+		return env.Subject, nil
+	}
+
+	var payload struct {
+		OrderID string `json:"order_id"`
+	}
+	if err := json.Unmarshal(env.Data, &payload); err != nil {
+		return "", err
+	}
+	if payload.OrderID == "" {
+		return "", fmt.Errorf("%s event has no order id", env.Type)
+	}
+
+	return payload.OrderID, nil
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// CloudEvents attributes are carried as NATS headers (binary content mode),
+// the message body is the event data itself
+const (
+	HeaderID            = "ce-id"
+	HeaderType          = "ce-type"
+	HeaderSource        = "ce-source"
+	HeaderSubject       = "ce-subject"
+	HeaderTime          = "ce-time"
+	HeaderSpecVersion   = "ce-specversion"
+	HeaderSchemaVersion = "ce-schemaversion"
+	HeaderContentType   = "content-type"
+
+	specVersion = "1.0"
+	contentType = "application/json"
+)
+
+var ErrTypeMismatch = errors.New("event type mismatch")
+
+// Event is implemented by every typed event struct
+type Event interface {
+	EventType() string
+	SchemaVersion() int
+}
+
+// Envelope is the metadata shared by all events plus the raw event data
+type Envelope struct {
+	ID            string
+	Type          string
+	Source        string
+	Subject       string
+	SchemaVersion int
+	OccurredAt    time.Time
+	Data          json.RawMessage
+}
+
+// New wraps an event in an envelope with a fresh id, the subject is the id of the entity
+// the event is about (the order id for order events)
+func New(source, subject string, event Event) (Envelope, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		ID:            nuid.Next(),
+		Type:          event.EventType(),
+		Source:        source,
+		Subject:       subject,
+		SchemaVersion: event.SchemaVersion(),
+		OccurredAt:    time.Now().UTC(),
+		Data:          data,
+	}, nil
+}
+
+// Header returns the envelope metadata as NATS headers
+func (e Envelope) Header() nats.Header {
+	h := nats.Header{}
+	h.Set(HeaderID, e.ID)
+	h.Set(HeaderType, e.Type)
+	h.Set(HeaderSource, e.Source)
+	h.Set(HeaderSpecVersion, specVersion)
+	h.Set(HeaderSchemaVersion, strconv.Itoa(e.SchemaVersion))
+	h.Set(HeaderTime, e.OccurredAt.Format(time.RFC3339Nano))
+	h.Set(HeaderContentType, contentType)
+	if e.Subject != "" {
+		h.Set(HeaderSubject, e.Subject)
+	}
+
+	return h
+}
+
+// Decode reads an envelope from a NATS message and upcasts it to the latest schema version.
+// Messages published before envelopes existed carry no headers, they are treated as
+// version 1 of the event named by their subject.
+func Decode(subject string, header nats.Header, data []byte) (Envelope, error) {
+	env := Envelope{
+		ID:            header.Get(HeaderID),
+		Type:          header.Get(HeaderType),
+		Source:        header.Get(HeaderSource),
+		Subject:       header.Get(HeaderSubject),
+		SchemaVersion: 1,
+		Data:          data,
+	}
+	if env.Type == "" {
+		env.Type = typeOf(subject)
+	}
+
+	if v := header.Get(HeaderSchemaVersion); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("invalid %s header %q: %w", HeaderSchemaVersion, v, err)
+		}
+		env.SchemaVersion = version
+	}
+
+	if t := header.Get(HeaderTime); t != "" {
+		occurredAt, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("invalid %s header %q: %w", HeaderTime, t, err)
+		}
+		env.OccurredAt = occurredAt
+	}
+
+	return Upcast(env)
+}
+
+// Unmarshal decodes the envelope data into the typed event, which must match the envelope type and version
+func (e Envelope) Unmarshal(event Event) error {
+	if e.Type != event.EventType() || e.SchemaVersion != event.SchemaVersion() {
+		return fmt.Errorf("%w: got %s v%d, want %s v%d", ErrTypeMismatch,
+			e.Type, e.SchemaVersion, event.EventType(), event.SchemaVersion())
+	}
+
+	return json.Unmarshal(e.Data, event)
+}
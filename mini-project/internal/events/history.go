@@ -0,0 +1,46 @@
+package events
+
+import "strings"
+
+// HistorySubjectPrefix is the subject space of the ORDERS_HISTORY stream. History subjects are
+// history.orders.<order id>.<event>, so the history of a single order can be read on its own.
+const HistorySubjectPrefix = "history.orders."
+
+// History only events, the ORDERS work queue never sees them
+const (
+	TypeOrderStatusChanged = "orders.status_changed"
+	TypeOrderSnapshot      = "orders.snapshot"
+)
+
+// HistorySubject returns the history subject of an event about an order
+func HistorySubject(orderID, eventType string) string {
+	return HistorySubjectPrefix + orderID + "." + strings.TrimPrefix(eventType, "orders.")
+}
+
+// OrderStatusChanged is recorded with every status change. It carries the whole order and its
+// version, so history events applied out of order still converge on the latest state.
+type OrderStatusChanged struct {
+	OrderID  string  `json:"order_id"`
+	TenantID string  `json:"tenant_id,omitempty"`
+	Item     string  `json:"item"`
+	Amount   float64 `json:"amount"`
+	From     string  `json:"from"`
+	Status   string  `json:"status"`
+	Version  int64   `json:"version"`
+}
+
+func (OrderStatusChanged) EventType() string  { return TypeOrderStatusChanged }
+func (OrderStatusChanged) SchemaVersion() int { return 1 }
+
+// OrderSnapshot records the state of an order that existed before its history was recorded
+type OrderSnapshot struct {
+	OrderID  string  `json:"order_id"`
+	TenantID string  `json:"tenant_id,omitempty"`
+	Item     string  `json:"item"`
+	Amount   float64 `json:"amount"`
+	Status   string  `json:"status"`
+	Version  int64   `json:"version"`
+}
+
+func (OrderSnapshot) EventType() string  { return TypeOrderSnapshot }
+func (OrderSnapshot) SchemaVersion() int { return 1 }
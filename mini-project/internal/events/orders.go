@@ -0,0 +1,45 @@
+package events
+
+import "encoding/json"
+
+const (
+	TypeOrderCreated   = "orders.created"
+	TypeOrderCancelled = "orders.cancelled"
+)
+
+// OrderCreated is published when a new order is saved. Events from before tenants
+// existed carry no tenant, their orders belong to the default tenant.
+type OrderCreated struct {
+	OrderID  string  `json:"order_id"`
+	TenantID string  `json:"tenant_id,omitempty"`
+	Item     string  `json:"item"`
+	Amount   float64 `json:"amount"`
+}
+
+func (OrderCreated) EventType() string  { return TypeOrderCreated }
+func (OrderCreated) SchemaVersion() int { return 2 }
+
+// OrderCancelled is published when a pending order is cancelled
+type OrderCancelled struct {
+	OrderID string `json:"order_id"`
+}
+
+func (OrderCancelled) EventType() string  { return TypeOrderCancelled }
+func (OrderCancelled) SchemaVersion() int { return 2 }
+
+func init() {
+	// Version 1 of the order events was the hand built {"id": "..."} payload
+	RegisterUpcaster(TypeOrderCreated, 1, renameOrderID)
+	RegisterUpcaster(TypeOrderCancelled, 1, renameOrderID)
+}
+
+func renameOrderID(data json.RawMessage) (json.RawMessage, error) {
+	var v1 struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]string{"order_id": v1.ID})
+}
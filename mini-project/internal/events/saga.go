@@ -0,0 +1,94 @@
+package events
+
+// Commands sent by the order saga and the outcome events the inventory and payment
+// services answer with. They share the ORDERS stream, each subject is owned by one consumer.
+const (
+	TypeReserveInventory  = "orders.reserve"
+	TypeReleaseInventory  = "orders.release"
+	TypeChargePayment     = "orders.charge"
+	TypeRefundPayment     = "orders.refund"
+	TypeInventoryReserved = "orders.reserved"
+	TypeReservationFailed = "orders.reservation_failed"
+	TypeInventoryReleased = "orders.released"
+	TypePaymentSucceeded  = "orders.paid"
+	TypePaymentFailed     = "orders.payment_failed"
+)
+
+// ReserveInventory asks the inventory service to hold one unit of the ordered item
+type ReserveInventory struct {
+	OrderID string `json:"order_id"`
+	Item    string `json:"item"`
+}
+
+func (ReserveInventory) EventType() string  { return TypeReserveInventory }
+func (ReserveInventory) SchemaVersion() int { return 1 }
+
+// ReleaseInventory asks the inventory service to give back the unit reserved for an order
+type ReleaseInventory struct {
+	OrderID string `json:"order_id"`
+	Item    string `json:"item"`
+}
+
+func (ReleaseInventory) EventType() string  { return TypeReleaseInventory }
+func (ReleaseInventory) SchemaVersion() int { return 1 }
+
+// ChargePayment asks the payment service to charge the order amount
+type ChargePayment struct {
+	OrderID string  `json:"order_id"`
+	Amount  float64 `json:"amount"`
+}
+
+func (ChargePayment) EventType() string  { return TypeChargePayment }
+func (ChargePayment) SchemaVersion() int { return 1 }
+
+// RefundPayment asks the payment service to pay back the charge of an order that failed after
+// it was charged. A refund arriving before its charge keeps the charge from being made.
+type RefundPayment struct {
+	OrderID string  `json:"order_id"`
+	Amount  float64 `json:"amount"`
+}
+
+func (RefundPayment) EventType() string  { return TypeRefundPayment }
+func (RefundPayment) SchemaVersion() int { return 1 }
+
+// InventoryReserved is published once the item of an order is reserved
+type InventoryReserved struct {
+	OrderID string `json:"order_id"`
+}
+
+func (InventoryReserved) EventType() string  { return TypeInventoryReserved }
+func (InventoryReserved) SchemaVersion() int { return 1 }
+
+// ReservationFailed is published when the item of an order cannot be reserved
+type ReservationFailed struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
+
+func (ReservationFailed) EventType() string  { return TypeReservationFailed }
+func (ReservationFailed) SchemaVersion() int { return 1 }
+
+// InventoryReleased is published once the reservation of an order is given back
+type InventoryReleased struct {
+	OrderID string `json:"order_id"`
+}
+
+func (InventoryReleased) EventType() string  { return TypeInventoryReleased }
+func (InventoryReleased) SchemaVersion() int { return 1 }
+
+// PaymentSucceeded is published once the order amount is charged
+type PaymentSucceeded struct {
+	OrderID string `json:"order_id"`
+}
+
+func (PaymentSucceeded) EventType() string  { return TypePaymentSucceeded }
+func (PaymentSucceeded) SchemaVersion() int { return 1 }
+
+// PaymentFailed is published when the order amount cannot be charged
+type PaymentFailed struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason"`
+}
+
+func (PaymentFailed) EventType() string  { return TypePaymentFailed }
+func (PaymentFailed) SchemaVersion() int { return 1 }
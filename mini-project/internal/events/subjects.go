@@ -0,0 +1,34 @@
+package events
+
+import "strings"
+
+// SubjectPrefix is the subject space of the ORDERS stream. Subjects are orders.<tenant>.<event>,
+// so the events of a tenant can be given to consumers of its own.
+const SubjectPrefix = "orders."
+
+// DefaultTenant owns the events published on the flat orders.<event> subjects from before tenants existed
+const DefaultTenant = "default"
+
+// Subject returns the subject an event of a tenant is published on
+func Subject(tenant, eventType string) string {
+	return SubjectPrefix + tenant + "." + strings.TrimPrefix(eventType, SubjectPrefix)
+}
+
+// TenantOf returns the tenant of an ORDERS subject, flat subjects belong to the default tenant
+func TenantOf(subject string) string {
+	if tokens := strings.Split(subject, "."); len(tokens) == 3 {
+		return tokens[1]
+	}
+
+	return DefaultTenant
+}
+
+// typeOf returns the type of the events published on a subject, which is the subject
+// itself for flat subjects
+func typeOf(subject string) string {
+	if tokens := strings.Split(subject, "."); len(tokens) == 3 && strings.HasPrefix(subject, SubjectPrefix) {
+		return SubjectPrefix + tokens[2]
+	}
+
+	return subject
+}
@@ -0,0 +1,39 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Upcaster converts the data of an event from one schema version to the next one
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+type upcastKey struct {
+	eventType   string
+	fromVersion int
+}
+
+var upcasters = map[upcastKey]Upcaster{}
+
+// RegisterUpcaster registers the conversion of eventType data from fromVersion to fromVersion+1.
+// It is meant to be called from init functions.
+func RegisterUpcaster(eventType string, fromVersion int, upcaster Upcaster) {
+	upcasters[upcastKey{eventType, fromVersion}] = upcaster
+}
+
+// Upcast applies registered upcasters until the envelope reaches the latest known version
+func Upcast(env Envelope) (Envelope, error) {
+	for {
+		upcaster, ok := upcasters[upcastKey{env.Type, env.SchemaVersion}]
+		if !ok {
+			return env, nil
+		}
+
+		data, err := upcaster(env.Data)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("upcasting %s v%d: %w", env.Type, env.SchemaVersion, err)
+		}
+		env.Data = data
+		env.SchemaVersion++
+	}
+}
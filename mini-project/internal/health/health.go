@@ -0,0 +1,164 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Check reports whether one dependency is usable
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs a set of checks concurrently, each bounded by the same timeout
+type Checker struct {
+	timeout time.Duration
+	checks  []namedCheck
+}
+
+// Result is the JSON body served by the checker's handler
+type Result struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a named check, it returns the checker so checks can be chained
+func (c *Checker) Add(name string, check Check) *Checker {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+	return c
+}
+
+// Run runs every check and reports whether all of them passed
+func (c *Checker) Run(ctx context.Context) (Result, bool) {
+	result := Result{Status: "ok", Checks: make(map[string]string, len(c.checks))}
+	healthy := true
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, named := range c.checks {
+		wg.Go(func() {
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			status := "ok"
+			if err := named.check(checkCtx); err != nil {
+				status = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Checks[named.name] = status
+			if status != "ok" {
+				healthy = false
+				result.Status = "fail"
+			}
+		})
+	}
+	wg.Wait()
+
+	return result, healthy
+}
+
+// Handler serves the check results, with 503 Service Unavailable when a check fails
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, healthy := c.Run(r.Context())
+
+		code := http.StatusOK
+		if !healthy {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// Postgres pings the database through the pool
+func Postgres(pgxPool *pgxpool.Pool) Check {
+	return func(ctx context.Context) error {
+		return pgxPool.Ping(ctx)
+	}
+}
+
+// NATSConnected fails unless the connection is currently connected, it fails while reconnecting
+func NATSConnected(nc *nats.Conn) Check {
+	return func(ctx context.Context) error {
+		if status := nc.Status(); status != nats.CONNECTED {
+			return fmt.Errorf("connection %s", status)
+		}
+		return nil
+	}
+}
+
+// NATSNotClosed only fails once the connection gave up reconnecting. A closed connection
+// never recovers, so the process has to be restarted.
+func NATSNotClosed(nc *nats.Conn) Check {
+	return func(ctx context.Context) error {
+		if nc.IsClosed() {
+			return fmt.Errorf("connection %s", nats.CLOSED)
+		}
+		return nil
+	}
+}
+
+// JetStream fetches the account info, which fails when JetStream is unavailable
+func JetStream(js jetstream.JetStream) Check {
+	return func(ctx context.Context) error {
+		_, err := js.AccountInfo(ctx)
+		return err
+	}
+}
+
+// Stream fails when the stream does not exist
+func Stream(js jetstream.JetStream, stream string) Check {
+	return func(ctx context.Context) error {
+		_, err := js.Stream(ctx, stream)
+		return err
+	}
+}
+
+// Consumer fails when the durable consumer does not exist
+func Consumer(js jetstream.JetStream, stream, consumer string) Check {
+	return func(ctx context.Context) error {
+		_, err := js.Consumer(ctx, stream, consumer)
+		return err
+	}
+}
+
+// Liveness only fails when restarting the process is the fix, a NATS connection that
+// exhausted its reconnect attempts stays closed forever
+func Liveness(timeout time.Duration, nc *nats.Conn) *Checker {
+	return NewChecker(timeout).
+		Add("nats", NATSNotClosed(nc))
+}
+
+// Readiness fails while any dependency needed to process orders is unavailable. Only the
+// consumers the service reads from are checked, services that just publish pass none.
+func Readiness(timeout time.Duration, pgxPool *pgxpool.Pool, nc *nats.Conn, js jetstream.JetStream, stream string, consumers ...string) *Checker {
+	c := NewChecker(timeout).
+		Add("postgres", Postgres(pgxPool)).
+		Add("nats", NATSConnected(nc)).
+		Add("jetstream", JetStream(js)).
+		Add("stream", Stream(js, stream))
+	for _, consumer := range consumers {
+		c.Add("consumer "+consumer, Consumer(js, stream, consumer))
+	}
+
+	return c
+}
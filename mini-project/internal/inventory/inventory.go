@@ -0,0 +1,151 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"nats-project/internal/config"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/outbox"
+	"nats-project/internal/retry"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// eventSource is the CloudEvents source of every event published by the inventory service
+const eventSource = "inventory"
+
+// Reservation statuses
+const (
+	Reserved = "RESERVED"
+	Rejected = "REJECTED"
+	Released = "RELEASED"
+)
+
+// Service reserves and releases one unit of the ordered item per order. Each order has at most
+// one row in inventory_reservations, which makes redelivered commands no-ops.
+type Service struct {
+	pgxPool      *pgxpool.Pool
+	initialStock int
+}
+
+func New(pgxPool *pgxpool.Pool, cfg config.Inventory) *Service {
+	return &Service{pgxPool: pgxPool, initialStock: cfg.InitialStock}
+}
+
+// Handle applies a reserve or release command and publishes its outcome through the outbox
+func (s *Service) Handle(ctx context.Context, msg jetstream.Msg) error {
+	env, err := events.Decode(msg.Subject(), msg.Headers(), msg.Data())
+	if err != nil {
+		return retry.Permanent(err)
+	}
+
+	// Outcomes go to the tenant of the command
+	tenant := events.TenantOf(msg.Subject())
+
+	switch env.Type {
+	case events.TypeReserveInventory:
+		var cmd events.ReserveInventory
+		if err := env.Unmarshal(&cmd); err != nil {
+			return retry.Permanent(err)
+		}
+		ctx, _ = logging.With(ctx, logging.KeyOrderID, cmd.OrderID)
+		return s.inTx(ctx, func(tx pgx.Tx) error { return s.reserve(ctx, tx, tenant, cmd) })
+	case events.TypeReleaseInventory:
+		var cmd events.ReleaseInventory
+		if err := env.Unmarshal(&cmd); err != nil {
+			return retry.Permanent(err)
+		}
+		ctx, _ = logging.With(ctx, logging.KeyOrderID, cmd.OrderID)
+		return s.inTx(ctx, func(tx pgx.Tx) error { return s.release(ctx, tx, tenant, cmd) })
+	default:
+		return retry.Permanent(fmt.Errorf("unexpected event type %s", env.Type))
+	}
+}
+
+func (s *Service) reserve(ctx context.Context, tx pgx.Tx, tenant string, cmd events.ReserveInventory) error {
+	logger := logging.FromContext(ctx)
+
+	tag, err := tx.Exec(ctx, `INSERT INTO inventory_reservations (order_id, item, status) VALUES ($1, $2, $3)
+		ON CONFLICT (order_id) DO NOTHING`, cmd.OrderID, cmd.Item, Reserved)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		logger.Info("reservation already handled")
+		return nil
+	}
+
+	// Items are stocked the first time an order asks for them
+	_, err = tx.Exec(ctx, "INSERT INTO inventory (item, available) VALUES ($1, $2) ON CONFLICT (item) DO NOTHING",
+		cmd.Item, s.initialStock)
+	if err != nil {
+		return err
+	}
+
+	tag, err = tx.Exec(ctx, "UPDATE inventory SET available = available - 1 WHERE item = $1 AND available > 0", cmd.Item)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		logger.Info("item out of stock", "item", cmd.Item)
+		_, err = tx.Exec(ctx, "UPDATE inventory_reservations SET status = $1, updated_at = now() WHERE order_id = $2",
+			Rejected, cmd.OrderID)
+		if err != nil {
+			return err
+		}
+		return publish(ctx, tx, tenant, cmd.OrderID, events.ReservationFailed{OrderID: cmd.OrderID, Reason: "item out of stock"})
+	}
+
+	logger.Info("item reserved", "item", cmd.Item)
+	return publish(ctx, tx, tenant, cmd.OrderID, events.InventoryReserved{OrderID: cmd.OrderID})
+}
+
+// release gives the reserved unit back. The outcome is published even when there was
+// nothing to release, so the saga never waits on a reservation that does not exist.
+func (s *Service) release(ctx context.Context, tx pgx.Tx, tenant string, cmd events.ReleaseInventory) error {
+	var item string
+	err := tx.QueryRow(ctx, `UPDATE inventory_reservations SET status = $1, updated_at = now()
+		WHERE order_id = $2 AND status = $3
+		RETURNING item`, Released, cmd.OrderID, Reserved).Scan(&item)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		logging.FromContext(ctx).Info("no reservation to release")
+	case err != nil:
+		return err
+	default:
+		if _, err = tx.Exec(ctx, "UPDATE inventory SET available = available + 1 WHERE item = $1", item); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("item released", "item", item)
+	}
+
+	return publish(ctx, tx, tenant, cmd.OrderID, events.InventoryReleased{OrderID: cmd.OrderID})
+}
+
+func (s *Service) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// publish stores an outcome event for tenant in the outbox of the current transaction
+func publish(ctx context.Context, tx pgx.Tx, tenant, orderID string, event events.Event) error {
+	env, err := events.New(eventSource, orderID, event)
+	if err != nil {
+		return err
+	}
+
+	return outbox.Insert(ctx, tx, events.Subject(tenant, event.EventType()), env)
+}
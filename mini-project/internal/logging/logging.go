@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"nats-project/internal/config"
+	"os"
+)
+
+// Attribute keys shared by every service, so one grep follows an order across all of them
+const (
+	KeyService   = "service"
+	KeyRequestID = "request_id"
+	KeyOrderID   = "order_id"
+	KeyTenant    = "tenant_id"
+	KeySubject   = "subject"
+	KeyStream    = "stream"
+	KeyStreamSeq = "stream_seq"
+	KeyDelivery  = "delivery"
+	KeyError     = "error"
+)
+
+type loggerKey struct{}
+
+// Setup makes a JSON logger at the configured level the default logger of the service.
+// Output of the standard log package goes through it as well.
+func Setup(service string, cfg config.Logging) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler).With(KeyService, service))
+}
+
+// NewContext returns ctx carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With returns ctx carrying the logger of ctx extended with args, and that logger
+func With(ctx context.Context, args ...any) (context.Context, *slog.Logger) {
+	logger := FromContext(ctx).With(args...)
+	return NewContext(ctx, logger), logger
+}
+
+// Fatal logs msg at error level and exits the service
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// HeaderRequestID carries the request id on HTTP requests and responses and on NATS messages
+const HeaderRequestID = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID returns the request id carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns ctx carrying the request id and a logger tagged with it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	ctx, _ = With(ctx, KeyRequestID, id)
+	return ctx
+}
+
+// InjectRequestID copies the request id of ctx into the message headers
+func InjectRequestID(ctx context.Context, header nats.Header) {
+	if id := RequestID(ctx); id != "" {
+		header.Set(HeaderRequestID, id)
+	}
+}
+
+// WithMessage returns ctx carrying a logger tagged with the request id, stream sequence
+// and delivery count of a JetStream message
+func WithMessage(ctx context.Context, msg jetstream.Msg) (context.Context, *slog.Logger) {
+	if id := msg.Headers().Get(HeaderRequestID); id != "" {
+		ctx = WithRequestID(ctx, id)
+	}
+
+	args := []any{KeySubject, msg.Subject()}
+	if md, err := msg.Metadata(); err == nil {
+		args = append(args, KeyStream, md.Stream, KeyStreamSeq, md.Sequence.Stream, KeyDelivery, md.NumDelivered)
+	}
+
+	return With(ctx, args...)
+}
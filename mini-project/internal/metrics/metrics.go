@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	publishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jetstream_publish_duration_seconds",
+		Help:    "Latency of JetStream publishes until the stream acked them.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"subject"})
+
+	publishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jetstream_publish_failures_total",
+		Help: "JetStream publishes that failed or were not acked.",
+	}, []string{"subject"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Postgres statement latency by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_query_errors_total",
+		Help: "Postgres statements that returned an error, by operation.",
+	}, []string{"operation"})
+
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_messages_received_total",
+		Help: "JetStream messages received by the consumer.",
+	}, []string{"subject"})
+
+	messagesSettled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_messages_settled_total",
+		Help: "JetStream messages acked, nacked or termed by the consumer workers.",
+	}, []string{"subject", "outcome"})
+
+	workerBusy = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_worker_busy_seconds_total",
+		Help: "Time consumer workers spent handling messages, divide its rate by the worker count for utilization.",
+	})
+
+	handleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "consumer_message_duration_seconds",
+		Help:    "Time a worker spent handling one message.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"subject"})
+)
+
+// Settle outcomes of a consumed message
+const (
+	Ack  = "ack"
+	Nak  = "nak"
+	Term = "term"
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// GinMiddleware records the count and latency of every request by route template,
+// so /order/:id is one series no matter how many orders exist
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObservePublish records the outcome of a JetStream publish
+func ObservePublish(subject string, duration time.Duration, err error) {
+	publishDuration.WithLabelValues(subject).Observe(duration.Seconds())
+	if err != nil {
+		publishFailures.WithLabelValues(subject).Inc()
+	}
+}
+
+// ObserveQuery records the outcome of a Postgres statement
+func ObserveQuery(operation string, duration time.Duration, err error) {
+	queryDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// MessageReceived counts a message handed to the consumer
+func MessageReceived(subject string) {
+	messagesReceived.WithLabelValues(subject).Inc()
+}
+
+// MessageSettled counts how a message was settled
+func MessageSettled(subject, outcome string) {
+	messagesSettled.WithLabelValues(subject, outcome).Inc()
+}
+
+// ObserveHandling records the time a worker was busy with one message
+func ObserveHandling(subject string, duration time.Duration) {
+	workerBusy.Add(duration.Seconds())
+	handleDuration.WithLabelValues(subject).Observe(duration.Seconds())
+}
+
+// RegisterQueueDepth exposes the number of messages of consumer waiting for a worker
+func RegisterQueueDepth(consumer string, depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "consumer_queue_depth",
+		Help:        "Messages, or batches in batch mode, received but not yet picked up by a worker.",
+		ConstLabels: prometheus.Labels{"consumer": consumer},
+	}, func() float64 {
+		return float64(depth())
+	})
+}
+
+var (
+	consumerWorkers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumer_workers",
+		Help: "Workers the adaptive controller currently runs.",
+	}, []string{"consumer"})
+
+	consumerBatchSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "consumer_fetch_batch_size",
+		Help: "Messages the consumer currently pulls per fetch.",
+	}, []string{"consumer"})
+)
+
+var (
+	eventStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_event_streams",
+		Help: "SSE and WebSocket order event streams currently open.",
+	})
+
+	eventStreamsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "http_event_streams_rejected_total",
+		Help: "Order event streams rejected because the maximum number of streams was open.",
+	})
+)
+
+// StreamOpened counts an order event stream as open
+func StreamOpened() {
+	eventStreams.Inc()
+}
+
+// StreamClosed counts an order event stream as closed
+func StreamClosed() {
+	eventStreams.Dec()
+}
+
+// StreamRejected counts an order event stream turned away at the stream limit
+func StreamRejected() {
+	eventStreamsRejected.Inc()
+}
+
+// SetConsumerLimits exposes the limits the adaptive controller chose for consumer
+func SetConsumerLimits(consumer string, workers, batch int) {
+	consumerWorkers.WithLabelValues(consumer).Set(float64(workers))
+	consumerBatchSize.WithLabelValues(consumer).Set(float64(batch))
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reads pgxpool.Stat on every scrape
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns        *prometheus.Desc
+	idleConns            *prometheus.Desc
+	constructingConns    *prometheus.Desc
+	totalConns           *prometheus.Desc
+	maxConns             *prometheus.Desc
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+}
+
+// RegisterPool exposes the statistics of the Postgres connection pool
+func RegisterPool(pool *pgxpool.Pool) {
+	prometheus.MustRegister(&poolCollector{
+		pool:                 pool,
+		acquiredConns:        prometheus.NewDesc("db_pool_acquired_conns", "Connections currently in use.", nil, nil),
+		idleConns:            prometheus.NewDesc("db_pool_idle_conns", "Idle connections in the pool.", nil, nil),
+		constructingConns:    prometheus.NewDesc("db_pool_constructing_conns", "Connections being established.", nil, nil),
+		totalConns:           prometheus.NewDesc("db_pool_total_conns", "Connections open in the pool.", nil, nil),
+		maxConns:             prometheus.NewDesc("db_pool_max_conns", "Maximum size of the pool.", nil, nil),
+		acquireCount:         prometheus.NewDesc("db_pool_acquires_total", "Successful connection acquires.", nil, nil),
+		acquireDuration:      prometheus.NewDesc("db_pool_acquire_duration_seconds_total", "Time spent waiting to acquire connections.", nil, nil),
+		emptyAcquireCount:    prometheus.NewDesc("db_pool_empty_acquires_total", "Acquires that had to wait because no idle connection was available.", nil, nil),
+		canceledAcquireCount: prometheus.NewDesc("db_pool_canceled_acquires_total", "Acquires cancelled by their context.", nil, nil),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.constructingConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquireCount
+	ch <- c.canceledAcquireCount
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructingConns, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
@@ -1,32 +1,59 @@
 package nats
 
 import (
-	"log"
-	"time"
+	"context"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"strings"
 
 	"github.com/nats-io/nats.go"
 )
 
-func InitNATS(name string) (*nats.Conn, error) {
+func InitNATS(name string, cfg config.NATS) (*nats.Conn, error) {
 	nc, err := nats.Connect(
-		"nats://localhost:4222, nats://localhost:4223, nats://localhost:4224",
+		strings.Join(cfg.URLs, ","),
 		nats.Name(name),
 		nats.DisconnectHandler(func(nc *nats.Conn) {
-			log.Println("disconnected from NATS server")
+			slog.Warn("disconnected from NATS server")
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
-			log.Println("reconnected to NATS server")
+			slog.Info("reconnected to NATS server", "url", nc.ConnectedUrl())
 		}),
 		nats.ClosedHandler(func(nc *nats.Conn) {
-			log.Println("connection to NATS server closed")
+			slog.Warn("connection to NATS server closed")
 		}),
-		nats.MaxReconnects(3),
-		nats.ReconnectWait(2*time.Second),
+		nats.MaxReconnects(cfg.MaxReconnects),
+		nats.ReconnectWait(cfg.ReconnectWait),
 	)
 	if err != nil {
-		log.Println("error connecting to NATS server:", err)
+		slog.Error("error connecting to NATS server", logging.KeyError, err)
 		return nil, err
 	}
 
 	return nc, nil
 }
+
+// Drain drains the connection and waits until it is closed, so every pending publish
+// and subscription callback has completed
+func Drain(ctx context.Context, nc *nats.Conn) error {
+	if nc.IsClosed() {
+		return nil
+	}
+
+	closed := make(chan struct{})
+	nc.SetClosedHandler(func(*nats.Conn) {
+		slog.Info("connection to NATS server closed")
+		close(closed)
+	})
+	if err := nc.Drain(); err != nil {
+		return err
+	}
+
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,135 @@
+package nats
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "nats-project/internal/nats"
+
+// propagator reads and writes the W3C traceparent, tracestate and baggage headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// HeaderCarrier lets OpenTelemetry propagators read and write NATS message headers
+type HeaderCarrier nats.Header
+
+func (c HeaderCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c HeaderCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InjectTrace writes the trace context of ctx into the headers
+func InjectTrace(ctx context.Context, header nats.Header) {
+	propagator.Inject(ctx, HeaderCarrier(header))
+}
+
+// ExtractTrace returns ctx carrying the trace context found in the headers, if any
+func ExtractTrace(ctx context.Context, header nats.Header) context.Context {
+	return propagator.Extract(ctx, HeaderCarrier(header))
+}
+
+// StartPublishSpan starts a producer span for msg and injects it into the message headers,
+// so the consumer's span becomes its child. The caller ends the span once the publish is acked.
+func StartPublishSpan(ctx context.Context, msg *nats.Msg) (context.Context, trace.Span) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "publish "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("nats"),
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName(msg.Subject),
+		),
+	)
+	InjectTrace(ctx, msg.Header)
+
+	return ctx, span
+}
+
+// StartConsumeSpan continues the trace carried by a JetStream message with a consumer span
+func StartConsumeSpan(ctx context.Context, msg jetstream.Msg) (context.Context, trace.Span) {
+	ctx = ExtractTrace(ctx, msg.Headers())
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("nats"),
+		semconv.MessagingOperationTypeProcess,
+		semconv.MessagingDestinationName(msg.Subject()),
+	}
+	if md, err := msg.Metadata(); err == nil {
+		attrs = append(attrs,
+			semconv.MessagingConsumerGroupName(md.Consumer),
+			attribute.Int64("messaging.nats.delivery_count", int64(md.NumDelivered)),
+		)
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, "process "+msg.Subject(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// StartConsumeBatchSpan starts a consumer span for messages processed together,
+// linked to the trace of every message in the batch
+func StartConsumeBatchSpan(ctx context.Context, msgs []jetstream.Msg) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(msgs))
+	for _, msg := range msgs {
+		spanCtx := trace.SpanContextFromContext(ExtractTrace(context.Background(), msg.Headers()))
+		if spanCtx.IsValid() {
+			links = append(links, trace.Link{SpanContext: spanCtx})
+		}
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, "process batch",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("nats"),
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingBatchMessageCount(len(msgs)),
+		),
+	)
+}
+
+// StartRequestSpan continues the trace carried by a core NATS request with a server span
+func StartRequestSpan(ctx context.Context, subject string, header nats.Header) (context.Context, trace.Span) {
+	ctx = ExtractTrace(ctx, header)
+
+	return otel.Tracer(tracerName).Start(ctx, "process "+subject,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("nats"),
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingDestinationName(subject),
+		),
+	)
+}
+
+// EndSpan records err on the span, if any, and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,74 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"nats-project/internal/events"
+	"nats-project/internal/outbox"
+	"regexp"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// historySource is the CloudEvents source of the order history events
+const historySource = "orders"
+
+// ErrInvalidID is returned for order ids that cannot be used as a subject token
+var ErrInvalidID = errors.New("order id must be 1 to 64 letters, digits, '-' or '_'")
+
+// idRegex keeps order ids usable as one token of the history subjects,
+// which rules out '.', the '*' and '>' wildcards and whitespace
+var idRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidID reports whether id can identify an order
+func ValidID(id string) bool {
+	return idRegex.MatchString(id)
+}
+
+// recordCreated stores the history event of a new order in the outbox of the current transaction
+func recordCreated(ctx context.Context, tx pgx.Tx, o Order) error {
+	env, err := events.New(historySource, o.ID, events.OrderCreated{
+		OrderID:  o.ID,
+		TenantID: o.TenantID,
+		Item:     o.Item,
+		Amount:   o.Amount,
+	})
+	if err != nil {
+		return err
+	}
+
+	return outbox.Insert(ctx, tx, events.HistorySubject(o.ID, events.TypeOrderCreated), env)
+}
+
+// statusChanged builds the history event of a status change, o holds the order after the change
+func statusChanged(o Order, from Status, version int64) (string, events.Envelope, error) {
+	env, err := events.New(historySource, o.ID, events.OrderStatusChanged{
+		OrderID:  o.ID,
+		TenantID: o.TenantID,
+		Item:     o.Item,
+		Amount:   o.Amount,
+		From:     string(from),
+		Status:   string(o.Status),
+		Version:  version,
+	})
+
+	return events.HistorySubject(o.ID, events.TypeOrderStatusChanged), env, err
+}
+
+// RecordSnapshot stores the current state of an order in the history, for orders created
+// before history was recorded. Applying it to a newer state of the order changes nothing.
+func RecordSnapshot(ctx context.Context, tx pgx.Tx, o Order, version int64) error {
+	env, err := events.New(historySource, o.ID, events.OrderSnapshot{
+		OrderID:  o.ID,
+		TenantID: o.TenantID,
+		Item:     o.Item,
+		Amount:   o.Amount,
+		Status:   string(o.Status),
+		Version:  version,
+	})
+	if err != nil {
+		return err
+	}
+
+	return outbox.Insert(ctx, tx, events.HistorySubject(o.ID, events.TypeOrderSnapshot), env)
+}
@@ -0,0 +1,234 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"nats-project/internal/events"
+	"nats-project/internal/outbox"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+var ErrNotFound = errors.New("order not found")
+
+// DefaultTenant owns the orders created before tenants existed and those of unauthenticated callers
+const DefaultTenant = events.DefaultTenant
+
+// TenantOrDefault returns tenant, or DefaultTenant for events recorded before tenants existed
+func TenantOrDefault(tenant string) string {
+	if tenant == "" {
+		return DefaultTenant
+	}
+
+	return tenant
+}
+
+// Order is a row of the orders table
+type Order struct {
+	ID       string  `json:"id"`
+	TenantID string  `json:"tenant_id"`
+	Item     string  `json:"item"`
+	Amount   float64 `json:"amount"`
+	Status   Status  `json:"status"`
+}
+
+// DB is implemented by pgxpool.Pool, pgxpool.Conn and pgx.Tx
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Create inserts a new order in the PENDING status and records it in the order history
+func Create(ctx context.Context, tx pgx.Tx, o Order) (Order, error) {
+	if !ValidID(o.ID) {
+		return Order{}, ErrInvalidID
+	}
+
+	o.Status = Pending
+	o.TenantID = TenantOrDefault(o.TenantID)
+	_, err := tx.Exec(ctx, "INSERT INTO orders (id, tenant_id, item, amount, status) VALUES ($1, $2, $3, $4, $5)",
+		o.ID, o.TenantID, o.Item, o.Amount, o.Status)
+	if err != nil {
+		return Order{}, err
+	}
+
+	if err = recordCreated(ctx, tx, o); err != nil {
+		return Order{}, err
+	}
+
+	return o, nil
+}
+
+// Get fetches a single order of a tenant by its id, an empty tenant matches orders of every tenant
+func Get(ctx context.Context, db DB, tenant, id string) (Order, error) {
+	var o Order
+	err := db.QueryRow(ctx, `SELECT id, tenant_id, item, amount, status FROM orders
+		WHERE id = $1 AND ($2 = '' OR tenant_id = $2)`, id, tenant).
+		Scan(&o.ID, &o.TenantID, &o.Item, &o.Amount, &o.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Order{}, ErrNotFound
+	}
+
+	return o, err
+}
+
+// CurrentStatus returns the status of an order of a tenant and the version it is at
+func CurrentStatus(ctx context.Context, db DB, tenant, id string) (Status, int64, error) {
+	var (
+		status  Status
+		version int64
+	)
+	err := db.QueryRow(ctx, "SELECT status, version FROM orders WHERE id = $1 AND ($2 = '' OR tenant_id = $2)", id, tenant).
+		Scan(&status, &version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, ErrNotFound
+	}
+
+	return status, version, err
+}
+
+// List returns up to limit orders ordered by id, starting after the cursor id.
+// An empty tenant, status or cursor disables that filter.
+func List(ctx context.Context, db DB, tenant string, status Status, cursor string, limit int) ([]Order, error) {
+	rows, err := db.Query(ctx, `SELECT id, tenant_id, item, amount, status FROM orders
+		WHERE ($1 = '' OR tenant_id = $1) AND ($2 = '' OR status = $2) AND ($3 = '' OR id > $3)
+		ORDER BY id
+		LIMIT $4`, tenant, string(status), cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []Order{}
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(&o.ID, &o.TenantID, &o.Item, &o.Amount, &o.Status); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+
+	return orders, rows.Err()
+}
+
+// transitionSQL bumps the version with every status change, the history events carry it
+const transitionSQL = `UPDATE orders SET status = $1, version = version + 1 WHERE id = $2 AND status = $3
+	RETURNING tenant_id, item, amount, version`
+
+// Transition moves an order from one status to another and records the change in the order history.
+// The expected status is checked in the UPDATE itself, so two concurrent transitions of the same order
+// cannot both win. It returns ErrNotFound for unknown orders and a *TransitionError when the transition
+// is not allowed or the order is no longer in the expected status.
+func Transition(ctx context.Context, tx pgx.Tx, id string, from, to Status) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{ID: id, From: from, To: to}
+	}
+
+	o := Order{ID: id, Status: to}
+	var version int64
+	err := tx.QueryRow(ctx, transitionSQL, to, id, from).Scan(&o.TenantID, &o.Item, &o.Amount, &version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		current, err := Get(ctx, tx, "", id)
+		if err != nil {
+			return err
+		}
+		return &TransitionError{ID: id, From: from, To: to, Current: current.Status}
+	}
+	if err != nil {
+		return err
+	}
+
+	subject, env, err := statusChanged(o, from, version)
+	if err != nil {
+		return err
+	}
+
+	return outbox.Insert(ctx, tx, subject, env)
+}
+
+// TransitionBatch moves every order in ids from one status to another in a single round trip,
+// plus one for recording the changes in the order history.
+// It returns one result per id, nil or the error Transition would have returned for it.
+// The error is only set when the batch itself failed.
+func TransitionBatch(ctx context.Context, tx pgx.Tx, ids []string, from, to Status) ([]error, error) {
+	results := make([]error, len(ids))
+	if !CanTransition(from, to) {
+		for i, id := range ids {
+			results[i] = &TransitionError{ID: id, From: from, To: to}
+		}
+		return results, nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, id := range ids {
+		batch.Queue(transitionSQL, to, id, from)
+	}
+
+	br := tx.SendBatch(ctx, batch)
+	var missed []string
+	history := &pgx.Batch{}
+	for i, id := range ids {
+		o := Order{ID: id, Status: to}
+		var version int64
+		err := br.QueryRow().Scan(&o.TenantID, &o.Item, &o.Amount, &version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			results[i] = ErrNotFound
+			missed = append(missed, id)
+			continue
+		}
+		if err == nil {
+			var subject string
+			var env events.Envelope
+			if subject, env, err = statusChanged(o, from, version); err == nil {
+				err = outbox.Queue(ctx, history, subject, env)
+			}
+		}
+		if err != nil {
+			br.Close()
+			return nil, err
+		}
+	}
+	if err := br.Close(); err != nil {
+		return nil, err
+	}
+
+	if history.Len() > 0 {
+		if err := tx.SendBatch(ctx, history).Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(missed) == 0 {
+		return results, nil
+	}
+
+	// Orders that were not updated either do not exist or are in another status
+	rows, err := tx.Query(ctx, "SELECT id, status FROM orders WHERE id = ANY($1)", missed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	current := make(map[string]Status, len(missed))
+	for rows.Next() {
+		var id string
+		var status Status
+		if err := rows.Scan(&id, &status); err != nil {
+			return nil, err
+		}
+		current[id] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		if status, ok := current[id]; ok && results[i] != nil {
+			results[i] = &TransitionError{ID: id, From: from, To: to, Current: status}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,73 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Status is the lifecycle state of an order
+type Status string
+
+const (
+	Pending    Status = "PENDING"
+	Processing Status = "PROCESSING"
+	Completed  Status = "COMPLETED"
+	Failed     Status = "FAILED"
+	Cancelled  Status = "CANCELLED"
+)
+
+// transitions lists the statuses each status may move to
+var transitions = map[Status][]Status{
+	// An order whose event exhausts its deliveries fails straight from PENDING
+	Pending:    {Processing, Cancelled, Failed},
+	Processing: {Completed, Failed},
+	// Replaying a dead-lettered event puts a failed order back in the queue
+	Failed: {Pending},
+}
+
+// ErrIllegalTransition is matched by every TransitionError
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+// TransitionError is returned when an order is not in the status a transition expects
+type TransitionError struct {
+	ID      string
+	From    Status
+	To      Status
+	Current Status
+}
+
+func (e *TransitionError) Error() string {
+	if e.Current == "" {
+		return fmt.Sprintf("illegal order status transition %s -> %s", e.From, e.To)
+	}
+	return fmt.Sprintf("illegal order status transition %s -> %s for order %s in status %s", e.From, e.To, e.ID, e.Current)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	switch s {
+	case Pending, Processing, Completed, Failed, Cancelled:
+		return true
+	}
+	return false
+}
+
+// IsTerminal reports whether the order reached a final status. FAILED is final for the
+// order flow, only an operator replaying the dead-letter queue moves it back to PENDING.
+func (s Status) IsTerminal() bool {
+	switch s {
+	case Completed, Failed, Cancelled:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether the state machine allows moving from one status to another
+func CanTransition(from, to Status) bool {
+	return slices.Contains(transitions[from], to)
+}
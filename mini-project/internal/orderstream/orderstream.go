@@ -0,0 +1,155 @@
+// Package orderstream describes the ORDERS work queue stream and its consumers. Every tenant
+// has a durable consumer per role, so a tenant with a backlog cannot hold up the orders of another.
+package orderstream
+
+import (
+	"context"
+	"fmt"
+	"nats-project/internal/events"
+	"nats-project/internal/retry"
+	"nats-project/internal/topology"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const StreamName = "ORDERS"
+
+// Role is a consumer of the ORDERS stream, it takes the events of EventTypes of one tenant
+type Role struct {
+	Durable       string
+	EventTypes    []string
+	MaxAckPending int
+}
+
+var (
+	Orders = Role{
+		Durable:       "ORDER_CONSUMER",
+		EventTypes:    []string{events.TypeOrderCreated},
+		MaxAckPending: 5,
+	}
+	Inventory = Role{
+		Durable:       "INVENTORY_CONSUMER",
+		EventTypes:    []string{events.TypeReserveInventory, events.TypeReleaseInventory},
+		MaxAckPending: 20,
+	}
+	Payment = Role{
+		Durable:       "PAYMENT_CONSUMER",
+		EventTypes:    []string{events.TypeChargePayment, events.TypeRefundPayment},
+		MaxAckPending: 20,
+	}
+	Saga = Role{
+		Durable: "SAGA_CONSUMER",
+		EventTypes: []string{events.TypeInventoryReserved, events.TypeReservationFailed,
+			events.TypePaymentSucceeded, events.TypePaymentFailed, events.TypeInventoryReleased},
+		MaxAckPending: 20,
+	}
+)
+
+// Roles are the consumers every tenant has. ORDERS is a work queue, so they own disjoint event types.
+var Roles = []Role{Orders, Inventory, Payment, Saga}
+
+// Tenants returns the default tenant followed by the configured ones
+func Tenants(configured []string) []string {
+	return append([]string{events.DefaultTenant}, configured...)
+}
+
+// Name returns the durable name of the consumer of a tenant. The default tenant keeps the name
+// the consumer had before tenants existed.
+func (r Role) Name(tenant string) string {
+	if tenant == events.DefaultTenant {
+		return r.Durable
+	}
+
+	return r.Durable + "_" + tenant
+}
+
+// Names returns the durable names of the consumers of the tenants
+func (r Role) Names(tenants []string) []string {
+	names := make([]string, 0, len(tenants))
+	for _, tenant := range tenants {
+		names = append(names, r.Name(tenant))
+	}
+
+	return names
+}
+
+// FilterSubjects returns the subjects of the consumer of a tenant. The default tenant also takes
+// the flat subjects stored before the stream mapped them to the default tenant.
+func (r Role) FilterSubjects(tenant string) []string {
+	var subjects []string
+	for _, eventType := range r.EventTypes {
+		subjects = append(subjects, events.Subject(tenant, eventType))
+	}
+	if tenant == events.DefaultTenant {
+		subjects = append(subjects, r.EventTypes...)
+	}
+
+	return subjects
+}
+
+// ConsumerConfig returns the consumer of a tenant. Workers nak with the delay of the retry policy,
+// roles with several event types take the MaxDeliver of their first one.
+func (r Role) ConsumerConfig(tenant string, policies retry.Policies) jetstream.ConsumerConfig {
+	subjects := r.FilterSubjects(tenant)
+	policy := policies.For(subjects[0])
+
+	return jetstream.ConsumerConfig{
+		Durable:        r.Name(tenant),
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		FilterSubjects: subjects,
+		MaxAckPending:  r.MaxAckPending,
+		AckWait:        retry.AckWait,
+		MaxDeliver:     policy.MaxDeliver,
+		ReplayPolicy:   jetstream.ReplayInstantPolicy,
+	}
+}
+
+// Consumers looks up the consumers of the tenants, in the order of tenants
+func (r Role) Consumers(ctx context.Context, js jetstream.JetStream, tenants []string) ([]jetstream.Consumer, error) {
+	consumers := make([]jetstream.Consumer, 0, len(tenants))
+	for _, tenant := range tenants {
+		consumer, err := js.Consumer(ctx, StreamName, r.Name(tenant))
+		if err != nil {
+			return nil, fmt.Errorf("consumer %s: %w", r.Name(tenant), err)
+		}
+		consumers = append(consumers, consumer)
+	}
+
+	return consumers, nil
+}
+
+// StreamConfig returns the ORDERS stream with room for the consumers of tenants. Publishes on the
+// flat orders.<event> subjects, from services not yet publishing per tenant, are stored under the default tenant.
+func StreamConfig(tenants []string) jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:     StreamName,
+		Subjects: []string{events.SubjectPrefix + "*.*", events.SubjectPrefix + "*"},
+		SubjectTransform: &jetstream.SubjectTransformConfig{
+			Source:      events.SubjectPrefix + "*",
+			Destination: events.SubjectPrefix + events.DefaultTenant + ".{{wildcard(1)}}",
+		},
+		Storage:   jetstream.FileStorage,
+		Retention: jetstream.WorkQueuePolicy,
+		MaxMsgs:   1000,
+		MaxAge:    time.Minute * 30,
+		Discard:   jetstream.DiscardOld,
+		// Two consumers are left for tools like the nats CLI
+		MaxConsumers: len(Roles)*len(tenants) + 2,
+		// Publishes with the same Nats-Msg-Id inside this window are dropped as duplicates
+		Duplicates: 2 * time.Minute,
+	}
+}
+
+// Topology returns the ORDERS stream and the consumers of the tenants, as created by stream-init,
+// for services/topology to reconcile
+func Topology(tenants []string, policies retry.Policies) (topology.Stream, error) {
+	var consumers []jetstream.ConsumerConfig
+	for _, tenant := range tenants {
+		for _, role := range Roles {
+			consumers = append(consumers, role.ConsumerConfig(tenant, policies))
+		}
+	}
+
+	return topology.NewStream(StreamConfig(tenants), consumers...)
+}
@@ -0,0 +1,187 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	ns "nats-project/internal/nats"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const publishTimeout = 5 * time.Second
+
+const insertSQL = "INSERT INTO outbox (subject, msg_id, headers, payload) VALUES ($1, $2, $3, $4)"
+
+// Insert stores an event in the outbox table as part of the caller's transaction,
+// so the event is only published if the transaction commits. The event id is sent as
+// Nats-Msg-Id so JetStream drops duplicates when a row is relayed more than once.
+// The trace context and request id of ctx are stored with the headers so consumers can correlate the event.
+func Insert(ctx context.Context, tx pgx.Tx, subject string, env events.Envelope) error {
+	headers, err := headersOf(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, insertSQL, subject, env.ID, headers, []byte(env.Data))
+	if err != nil {
+		logging.FromContext(ctx).Error("error inserting event into outbox", logging.KeySubject, subject, logging.KeyError, err)
+		return err
+	}
+
+	return nil
+}
+
+// Queue is Insert for a batch, the event is stored when the batch is sent within the caller's transaction
+func Queue(ctx context.Context, batch *pgx.Batch, subject string, env events.Envelope) error {
+	headers, err := headersOf(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	batch.Queue(insertSQL, subject, env.ID, headers, []byte(env.Data))
+	return nil
+}
+
+func headersOf(ctx context.Context, env events.Envelope) ([]byte, error) {
+	header := env.Header()
+	ns.InjectTrace(ctx, header)
+	logging.InjectRequestID(ctx, header)
+
+	return json.Marshal(header)
+}
+
+// Relay publishes pending outbox rows to JetStream and marks them as sent
+type Relay struct {
+	pgxPool   *pgxpool.Pool
+	js        jetstream.JetStream
+	interval  time.Duration
+	batchSize int
+}
+
+type outboxMsg struct {
+	id      int64
+	subject string
+	msgID   string
+	headers []byte
+	payload []byte
+}
+
+// NewRelay creates a new outbox relay
+func NewRelay(pgxPool *pgxpool.Pool, js jetstream.JetStream, cfg config.Outbox) *Relay {
+	return &Relay{
+		pgxPool:   pgxPool,
+		js:        js,
+		interval:  cfg.Interval,
+		batchSize: cfg.BatchSize,
+	}
+}
+
+// Run polls the outbox table until the context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := r.relayBatch(ctx)
+		if err != nil {
+			slog.Error("error relaying outbox messages", logging.KeyError, err)
+		}
+
+		// A full batch means more rows are probably waiting, so keep going without waiting for the ticker
+		if err == nil && sent == r.batchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayBatch publishes one batch of unsent rows. Rows are locked with SKIP LOCKED so
+// several relays can run side by side without publishing the same row concurrently.
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	tx, err := r.pgxPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT id, subject, msg_id, headers, payload FROM outbox
+		WHERE sent_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var msgs []outboxMsg
+	for rows.Next() {
+		var m outboxMsg
+		if err := rows.Scan(&m.id, &m.subject, &m.msgID, &m.headers, &m.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		msgs = append(msgs, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	// Stop at the first failure so events keep their outbox order; the rest are retried on the next run
+	var publishErr error
+	sentIDs := make([]int64, 0, len(msgs))
+	for _, m := range msgs {
+		msg := &nats.Msg{Subject: m.subject, Data: m.payload, Header: nats.Header{}}
+		if publishErr = json.Unmarshal(m.headers, &msg.Header); publishErr != nil {
+			slog.Error("error decoding headers of outbox message", "outbox_id", m.id, logging.KeyError, publishErr)
+			break
+		}
+
+		// The publish span joins the trace of the request that wrote the row
+		spanCtx, span := ns.StartPublishSpan(ns.ExtractTrace(ctx, msg.Header), msg)
+		pubCtx, cancel := context.WithTimeout(spanCtx, publishTimeout)
+		start := time.Now()
+		_, publishErr = r.js.PublishMsg(pubCtx, msg, jetstream.WithMsgID(m.msgID))
+		metrics.ObservePublish(m.subject, time.Since(start), publishErr)
+		ns.EndSpan(span, publishErr)
+		cancel()
+		if publishErr != nil {
+			slog.Error("error publishing outbox message", "outbox_id", m.id, logging.KeySubject, m.subject,
+				logging.KeyRequestID, msg.Header.Get(logging.HeaderRequestID), logging.KeyError, publishErr)
+			break
+		}
+		sentIDs = append(sentIDs, m.id)
+	}
+
+	if len(sentIDs) > 0 {
+		_, err = tx.Exec(ctx, "UPDATE outbox SET sent_at = now() WHERE id = ANY($1)", sentIDs)
+		if err != nil {
+			return 0, err
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(sentIDs), publishErr
+}
@@ -0,0 +1,129 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"nats-project/internal/config"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/outbox"
+	"nats-project/internal/retry"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// eventSource is the CloudEvents source of every event published by the payment service
+const eventSource = "payment"
+
+// Payment statuses
+const (
+	Paid     = "PAID"
+	Declined = "DECLINED"
+	Refunded = "REFUNDED"
+)
+
+// Service charges orders and refunds them. Each order has at most one row in payments, so a
+// redelivered charge command is never charged twice.
+type Service struct {
+	pgxPool   *pgxpool.Pool
+	maxAmount float64
+}
+
+func New(pgxPool *pgxpool.Pool, cfg config.Payment) *Service {
+	return &Service{pgxPool: pgxPool, maxAmount: cfg.MaxAmount}
+}
+
+// Handle applies a charge or refund command. Charge outcomes are published through the outbox,
+// refunds are not answered because the saga does not wait for them.
+func (s *Service) Handle(ctx context.Context, msg jetstream.Msg) error {
+	env, err := events.Decode(msg.Subject(), msg.Headers(), msg.Data())
+	if err != nil {
+		return retry.Permanent(err)
+	}
+
+	switch env.Type {
+	case events.TypeChargePayment:
+		var cmd events.ChargePayment
+		if err := env.Unmarshal(&cmd); err != nil {
+			return retry.Permanent(err)
+		}
+		// The outcome goes to the tenant of the command
+		return s.charge(ctx, events.TenantOf(msg.Subject()), cmd)
+	case events.TypeRefundPayment:
+		var cmd events.RefundPayment
+		if err := env.Unmarshal(&cmd); err != nil {
+			return retry.Permanent(err)
+		}
+		return s.refund(ctx, cmd)
+	default:
+		return retry.Permanent(fmt.Errorf("unexpected event type %s", env.Type))
+	}
+}
+
+func (s *Service) charge(ctx context.Context, tenant string, cmd events.ChargePayment) error {
+	ctx, logger := logging.With(ctx, logging.KeyOrderID, cmd.OrderID)
+
+	// Stands in for a payment provider: charges above the configured limit are declined
+	status := Paid
+	var outcome events.Event = events.PaymentSucceeded{OrderID: cmd.OrderID}
+	if cmd.Amount > s.maxAmount {
+		status = Declined
+		outcome = events.PaymentFailed{
+			OrderID: cmd.OrderID,
+			Reason:  fmt.Sprintf("amount %.2f exceeds the limit of %.2f", cmd.Amount, s.maxAmount),
+		}
+	}
+
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `INSERT INTO payments (order_id, amount, status) VALUES ($1, $2, $3)
+		ON CONFLICT (order_id) DO NOTHING`, cmd.OrderID, cmd.Amount, status)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		logger.Info("payment already handled or refunded")
+		return nil
+	}
+
+	event, err := events.New(eventSource, cmd.OrderID, outcome)
+	if err != nil {
+		return err
+	}
+	subject := events.Subject(tenant, outcome.EventType())
+	if err = outbox.Insert(ctx, tx, subject, event); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	logger.Info("payment handled", "status", status, "amount", cmd.Amount)
+	return nil
+}
+
+// refund pays back the charge of an order. Without a charge yet, the refunded row keeps a
+// charge command still on its way from being made.
+func (s *Service) refund(ctx context.Context, cmd events.RefundPayment) error {
+	ctx, logger := logging.With(ctx, logging.KeyOrderID, cmd.OrderID)
+
+	tag, err := s.pgxPool.Exec(ctx, `INSERT INTO payments (order_id, amount, status) VALUES ($1, $2, $3)
+		ON CONFLICT (order_id) DO UPDATE SET status = $3 WHERE payments.status = $4`,
+		cmd.OrderID, cmd.Amount, Refunded, Paid)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		logger.Info("nothing to refund, the payment was declined or already refunded")
+		return nil
+	}
+
+	logger.Info("payment refunded", "amount", cmd.Amount)
+	return nil
+}
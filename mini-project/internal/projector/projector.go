@@ -0,0 +1,388 @@
+package projector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// StreamName is the limits retention stream keeping the history of every order
+	StreamName = "ORDERS_HISTORY"
+
+	// table is the read model built from the history, shadowTable is where a rebuild replays into
+	table       = "orders"
+	shadowTable = "orders_rebuild"
+
+	// swapTimeout bounds how long a rebuild holds the orders table locked while swapping
+	swapTimeout  = 30 * time.Second
+	outboxPoll   = 100 * time.Millisecond
+	replayReport = 10_000
+)
+
+// StreamConfig returns the history stream. It has no limits, a rebuild replays it from the start.
+func StreamConfig() jetstream.StreamConfig {
+	return jetstream.StreamConfig{
+		Name:       StreamName,
+		Subjects:   []string{events.HistorySubjectPrefix + ">"},
+		Storage:    jetstream.FileStorage,
+		Retention:  jetstream.LimitsPolicy,
+		Discard:    jetstream.DiscardOld,
+		Duplicates: 2 * time.Minute,
+	}
+}
+
+// Projector applies the ORDERS_HISTORY stream to the orders table. It reads the stream with
+// ordered consumers, so it needs no durable consumer, and keeps its position in projector_checkpoints.
+type Projector struct {
+	pgxPool *pgxpool.Pool
+	js      jetstream.JetStream
+}
+
+func New(pgxPool *pgxpool.Pool, js jetstream.JetStream) *Projector {
+	return &Projector{pgxPool: pgxPool, js: js}
+}
+
+// Follow applies history events to the orders table as they arrive, starting after the
+// checkpoint, until ctx is cancelled. Each event commits together with the new checkpoint.
+func (p *Projector) Follow(ctx context.Context) error {
+	seq, err := p.checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	it, err := p.messages(ctx, seq+1)
+	if err != nil {
+		return err
+	}
+	defer it.Stop()
+	slog.Info("following order history", "from_seq", seq+1)
+
+	for {
+		msg, err := it.Next(jetstream.NextContext(ctx))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		tx, err := p.pgxPool.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		seq, err = apply(ctx, tx, table, msg)
+		if err == nil {
+			err = saveCheckpoint(ctx, tx, seq)
+		}
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		if err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+}
+
+// Rebuild replays the whole history into an empty shadow table and then swaps it in for
+// the orders table. Only the swap locks the orders table, writes continue during the replay.
+func (p *Projector) Rebuild(ctx context.Context) error {
+	if _, err := p.pgxPool.Exec(ctx, "DROP TABLE IF EXISTS "+shadowTable); err != nil {
+		return err
+	}
+	if _, err := p.pgxPool.Exec(ctx, fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING ALL)", shadowTable, table)); err != nil {
+		return fmt.Errorf("creating shadow table: %w", err)
+	}
+
+	last, err := p.lastSeq(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The shadow table is invisible until the swap, so events need no transaction here
+	if err = p.replay(ctx, p.pgxPool, 1, last); err != nil {
+		return fmt.Errorf("replaying order history: %w", err)
+	}
+	slog.Info("replayed order history into shadow table", "last_seq", last)
+
+	return p.swap(ctx, last)
+}
+
+// swap catches the shadow table up and replaces the orders table with it in one transaction.
+// Orders are locked first, so no new history events can be written, and the history still
+// sitting in the outbox is published before the last events are replayed.
+func (p *Projector) swap(ctx context.Context, replayed uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, swapTimeout)
+	defer cancel()
+
+	tx, err := p.pgxPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, "LOCK TABLE "+table+" IN ACCESS EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	if err = waitForOutbox(ctx, tx); err != nil {
+		return err
+	}
+
+	last, err := p.lastSeq(ctx)
+	if err != nil {
+		return err
+	}
+	if err = p.replay(ctx, tx, replayed+1, last); err != nil {
+		return fmt.Errorf("catching up order history: %w", err)
+	}
+
+	if _, err = tx.Exec(ctx, "DROP TABLE "+table); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", shadowTable, table)); err != nil {
+		return err
+	}
+	if err = renameIndexes(ctx, tx); err != nil {
+		return err
+	}
+	if err = saveCheckpoint(ctx, tx, last); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	slog.Info("swapped rebuilt orders table in", "last_seq", last)
+	return nil
+}
+
+// replay applies the events with stream sequences from..to to the shadow table
+func (p *Projector) replay(ctx context.Context, db order.DB, from, to uint64) error {
+	if from > to {
+		return nil
+	}
+
+	it, err := p.messages(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer it.Stop()
+
+	for seq := uint64(0); seq < to; {
+		msg, err := it.Next(jetstream.NextContext(ctx))
+		if err != nil {
+			return err
+		}
+		if seq, err = apply(ctx, db, shadowTable, msg); err != nil {
+			return err
+		}
+		if seq%replayReport == 0 {
+			slog.Info("replaying order history", "seq", seq, "last_seq", to)
+		}
+	}
+
+	return nil
+}
+
+// messages reads the history stream from a sequence on with an ordered consumer
+func (p *Projector) messages(ctx context.Context, from uint64) (jetstream.MessagesContext, error) {
+	consumer, err := p.js.OrderedConsumer(ctx, StreamName, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{events.HistorySubjectPrefix + ">"},
+		DeliverPolicy:  jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:    from,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return consumer.Messages()
+}
+
+func (p *Projector) lastSeq(ctx context.Context) (uint64, error) {
+	stream, err := p.js.Stream(ctx, StreamName)
+	if err != nil {
+		return 0, err
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.State.LastSeq, nil
+}
+
+func (p *Projector) checkpoint(ctx context.Context) (uint64, error) {
+	var seq uint64
+	err := p.pgxPool.QueryRow(ctx, "SELECT stream_seq FROM projector_checkpoints WHERE name = $1", table).Scan(&seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+
+	return seq, err
+}
+
+func saveCheckpoint(ctx context.Context, tx pgx.Tx, seq uint64) error {
+	_, err := tx.Exec(ctx, `INSERT INTO projector_checkpoints (name, stream_seq) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET stream_seq = EXCLUDED.stream_seq, updated_at = now()`, table, seq)
+	return err
+}
+
+// apply writes one history event to the given table and returns its stream sequence. Every event
+// carries the order version it produced, so applying events twice or out of order converges on the latest one.
+func apply(ctx context.Context, db order.DB, into string, msg jetstream.Msg) (uint64, error) {
+	md, err := msg.Metadata()
+	if err != nil {
+		return 0, err
+	}
+	seq := md.Sequence.Stream
+
+	env, err := events.Decode(msg.Subject(), msg.Headers(), msg.Data())
+	if err != nil {
+		return seq, fmt.Errorf("decoding history event %d: %w", seq, err)
+	}
+
+	upsert := fmt.Sprintf(`INSERT INTO %[1]s (id, tenant_id, item, amount, status, version) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, version = EXCLUDED.version
+		WHERE %[1]s.version < EXCLUDED.version`, into)
+
+	switch env.Type {
+	case events.TypeOrderCreated:
+		var e events.OrderCreated
+		if err = env.Unmarshal(&e); err == nil {
+			_, err = db.Exec(ctx, upsert, e.OrderID, order.TenantOrDefault(e.TenantID), e.Item, e.Amount, order.Pending, 1)
+		}
+	case events.TypeOrderStatusChanged:
+		var e events.OrderStatusChanged
+		if err = env.Unmarshal(&e); err == nil {
+			_, err = db.Exec(ctx, upsert, e.OrderID, order.TenantOrDefault(e.TenantID), e.Item, e.Amount, e.Status, e.Version)
+		}
+	case events.TypeOrderSnapshot:
+		var e events.OrderSnapshot
+		if err = env.Unmarshal(&e); err == nil {
+			_, err = db.Exec(ctx, upsert, e.OrderID, order.TenantOrDefault(e.TenantID), e.Item, e.Amount, e.Status, e.Version)
+		}
+	default:
+		// Event types added later are skipped rather than stopping an older projector
+		logging.FromContext(ctx).Debug("skipping unknown history event", "type", env.Type, logging.KeyStreamSeq, seq)
+	}
+	if err != nil {
+		return seq, fmt.Errorf("applying history event %d: %w", seq, err)
+	}
+
+	return seq, nil
+}
+
+// waitForOutbox waits until every history event committed to the outbox has been published
+func waitForOutbox(ctx context.Context, tx pgx.Tx) error {
+	for {
+		var pending int
+		err := tx.QueryRow(ctx, "SELECT count(*) FROM outbox WHERE sent_at IS NULL AND subject LIKE $1",
+			events.HistorySubjectPrefix+"%").Scan(&pending)
+		if err != nil {
+			return err
+		}
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d unpublished history events: %w", pending, ctx.Err())
+		case <-time.After(outboxPoll):
+		}
+	}
+}
+
+// renameIndexes gives the indexes created for the shadow table the names of the orders table
+func renameIndexes(ctx context.Context, tx pgx.Tx) error {
+	rows, err := tx.Query(ctx, "SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = $1", table)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, shadowTable) {
+			continue
+		}
+		renamed := table + strings.TrimPrefix(name, shadowTable)
+		_, err := tx.Exec(ctx, fmt.Sprintf("ALTER INDEX %s RENAME TO %s",
+			pgx.Identifier{name}.Sanitize(), pgx.Identifier{renamed}.Sanitize()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Backfill records a snapshot of every order in the history, so orders created before
+// history was recorded survive a rebuild. Snapshots of orders that changed since are ignored by the projector.
+func (p *Projector) Backfill(ctx context.Context) (int, error) {
+	tx, err := p.pgxPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "SELECT id, tenant_id, item, amount, status, version FROM orders ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	type snapshot struct {
+		order   order.Order
+		version int64
+	}
+	var snapshots []snapshot
+	for rows.Next() {
+		var s snapshot
+		if err := rows.Scan(&s.order.ID, &s.order.TenantID, &s.order.Item, &s.order.Amount, &s.order.Status, &s.version); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	recorded := 0
+	for _, s := range snapshots {
+		// Ids from before they were validated may not fit in a subject
+		if !order.ValidID(s.order.ID) {
+			slog.Warn("skipping order whose id cannot be a subject token", logging.KeyOrderID, s.order.ID)
+			continue
+		}
+		if err := order.RecordSnapshot(ctx, tx, s.order, s.version); err != nil {
+			return 0, err
+		}
+		recorded++
+	}
+
+	return recorded, tx.Commit(ctx)
+}
@@ -0,0 +1,155 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// permanentError marks an error that will fail again on every redelivery
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as permanent, the message is terminated instead of retried.
+// Errors that are not marked are treated as transient.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// AckWait is the ack wait of the consumers whose messages are settled with a Policy. It is set
+// explicitly instead of through a consumer BackOff, whose first value would replace it and could
+// be shorter than the handling of a message. Workers report progress on messages they hold for
+// longer, see worker.KeepAlive.
+const AckWait = 30 * time.Second
+
+// Policy is an exponential backoff schedule for redeliveries
+type Policy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64
+	MaxDeliver   int
+}
+
+func newPolicy(cfg config.RetryPolicy) Policy {
+	return Policy{
+		InitialDelay: cfg.InitialDelay,
+		MaxDelay:     cfg.MaxDelay,
+		Multiplier:   cfg.Multiplier,
+		Jitter:       cfg.Jitter,
+		MaxDeliver:   cfg.MaxDeliver,
+	}
+}
+
+// baseDelay is the delay before the redelivery following the given delivery, without jitter
+func (p Policy) baseDelay(delivery int) time.Duration {
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(max(delivery-1, 0)))
+	return time.Duration(min(delay, float64(p.MaxDelay)))
+}
+
+// Delay returns the jittered delay before redelivering a message that failed on the given
+// delivery, delivery 1 being the first attempt
+func (p Policy) Delay(delivery int) time.Duration {
+	delay := float64(p.baseDelay(delivery))
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// Settle acks the message when err is nil, terminates it when err is permanent and
+// otherwise naks it with the backoff delay for its delivery count
+func (p Policy) Settle(ctx context.Context, msg jetstream.Msg, err error) error {
+	if err == nil {
+		metrics.MessageSettled(msg.Subject(), metrics.Ack)
+		return msg.Ack()
+	}
+
+	if IsPermanent(err) {
+		logging.FromContext(ctx).Warn("terminating message after permanent error", logging.KeyError, err)
+		metrics.MessageSettled(msg.Subject(), metrics.Term)
+		return msg.Term()
+	}
+
+	delivery := 1
+	if md, mdErr := msg.Metadata(); mdErr == nil {
+		delivery = int(md.NumDelivered)
+	}
+	delay := p.Delay(delivery)
+	logging.FromContext(ctx).Warn("retrying message after failed delivery", "retry_in", delay, logging.KeyError, err)
+	metrics.MessageSettled(msg.Subject(), metrics.Nak)
+
+	return msg.NakWithDelay(delay)
+}
+
+// Policies holds the default policy and its per subject overrides
+type Policies struct {
+	defaultPolicy Policy
+	subjects      map[string]Policy
+}
+
+// NewPolicies builds the retry policies from the configuration
+func NewPolicies(cfg config.Retry) Policies {
+	policies := Policies{
+		defaultPolicy: newPolicy(cfg.Default),
+		subjects:      make(map[string]Policy, len(cfg.Subjects)),
+	}
+	for subject, policy := range cfg.Subjects {
+		policies.subjects[subject] = newPolicy(policy)
+	}
+
+	return policies
+}
+
+// For returns the policy configured for subject, or the default policy. Patterns may use
+// the NATS * and > wildcards, an exact subject wins over patterns, which are tried in
+// lexical order.
+func (p Policies) For(subject string) Policy {
+	if policy, ok := p.subjects[subject]; ok {
+		return policy
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(p.subjects)) {
+		if subjectMatches(pattern, subject) {
+			return p.subjects[pattern]
+		}
+	}
+
+	return p.defaultPolicy
+}
+
+func subjectMatches(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(subjectTokens)
+}
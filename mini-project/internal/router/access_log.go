@@ -0,0 +1,33 @@
+package router
+
+import (
+	"log/slog"
+	"nats-project/internal/logging"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog logs every request once it completes. The log goes through the logger of the
+// request context, so it carries the request id and, once authenticated, the tenant and caller.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		ctx := c.Request.Context()
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logging.FromContext(ctx).Log(ctx, level, "request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"bytes", max(c.Writer.Size(), 0),
+			"client_ip", c.ClientIP(),
+			"duration", time.Since(start),
+		)
+	}
+}
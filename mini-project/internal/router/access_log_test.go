@@ -0,0 +1,57 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"nats-project/internal/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	// A tag added by a later middleware, like the tenant of Authenticate, ends up in the log
+	tag := func(c *gin.Context) {
+		ctx, _ := logging.With(c.Request.Context(), logging.KeyTenant, "acme")
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+	failing := func(c *gin.Context) {
+		c.String(http.StatusInternalServerError, "boom")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test?x=1", nil)
+	req.Header.Set(logging.HeaderRequestID, "req-1")
+	serve(req, failing, RequestID(true), AccessLog(), tag)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding log %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"level":              "ERROR",
+		"msg":                "request completed",
+		"method":             "GET",
+		"path":               "/test",
+		"route":              "/test",
+		"status":             float64(http.StatusInternalServerError),
+		"bytes":              float64(len("boom")),
+		logging.KeyRequestID: "req-1",
+		logging.KeyTenant:    "acme",
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Error("log has no duration")
+	}
+}
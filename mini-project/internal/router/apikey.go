@@ -0,0 +1,57 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"nats-project/internal/config"
+	"net/http"
+)
+
+// HeaderAPIKey carries the API key of a request
+const HeaderAPIKey = "X-API-Key"
+
+// APIKeys authenticates requests by the X-API-Key header. Keys are looked up by their
+// SHA-256, so only hashes are kept in memory and in the config file.
+type APIKeys struct {
+	keys map[[sha256.Size]byte]Principal
+}
+
+func NewAPIKeys(keys []config.APIKey) (*APIKeys, error) {
+	a := &APIKeys{keys: make(map[[sha256.Size]byte]Principal, len(keys))}
+	for i, key := range keys {
+		var sum [sha256.Size]byte
+		if n, err := hex.Decode(sum[:], []byte(key.SHA256)); err != nil || n != sha256.Size {
+			return nil, fmt.Errorf("api key %d: sha256 must be a hex encoded SHA-256", i)
+		}
+		if !tenantRegex.MatchString(key.Tenant) {
+			return nil, fmt.Errorf("api key %d: tenant %q cannot be used as a subject token", i, key.Tenant)
+		}
+		if _, ok := a.keys[sum]; ok {
+			return nil, fmt.Errorf("api key %d is configured twice", i)
+		}
+
+		subject := key.Subject
+		if subject == "" {
+			subject = "api-key-" + key.SHA256[:8]
+		}
+		a.keys[sum] = Principal{Tenant: key.Tenant, Subject: subject}
+	}
+
+	return a, nil
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	key := r.Header.Get(HeaderAPIKey)
+	if key == "" {
+		return Principal{}, ErrNoCredentials
+	}
+
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Principal{}, errors.New("unknown api key")
+	}
+
+	return p, nil
+}
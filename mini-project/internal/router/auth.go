@@ -0,0 +1,139 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrNoCredentials is returned by an Authenticator for requests without its kind of credentials
+var ErrNoCredentials = errors.New("no credentials")
+
+// tenantRegex keeps tenants usable as a subject token, like order ids
+var tenantRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Tenant  string
+	Subject string
+}
+
+// Authenticator verifies one kind of credentials, such as API keys or bearer tokens
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator
+type AuthenticatorFunc func(r *http.Request) (Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (Principal, error) {
+	return f(r)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns ctx carrying the caller of the request
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the caller stored in ctx by Authenticate
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Authenticate rejects requests none of the authenticators accepts. The first authenticator
+// finding its kind of credentials decides, so invalid credentials are never retried as anonymous.
+// The accepted caller is stored in the request context and tags the request's logger.
+func Authenticate(authenticators ...Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		for _, a := range authenticators {
+			p, err := a.Authenticate(c.Request)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			if err == nil && !tenantRegex.MatchString(p.Tenant) {
+				err = fmt.Errorf("tenant %q cannot be used as a subject token", p.Tenant)
+			}
+			if err != nil {
+				logging.FromContext(ctx).Info("rejected request credentials", logging.KeyError, err)
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+				return
+			}
+
+			ctx, _ = logging.With(WithPrincipal(ctx, p), logging.KeyTenant, p.Tenant, "caller", p.Subject)
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+			return
+		}
+
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+	}
+}
+
+// RequireTenant rejects callers of tenants missing from tenants with 403. Orders of such
+// tenants would wait in the ORDERS stream without a consumer taking them.
+func RequireTenant(tenants []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := PrincipalFrom(c.Request.Context())
+		if !ok || !slices.Contains(tenants, p.Tenant) {
+			logging.FromContext(c.Request.Context()).Warn("rejected caller of unknown tenant")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "tenant is not provisioned"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Anonymous accepts every request as the default tenant, it belongs last in the chain
+func Anonymous() Authenticator {
+	return AuthenticatorFunc(func(*http.Request) (Principal, error) {
+		return Principal{Tenant: order.DefaultTenant, Subject: "anonymous"}, nil
+	})
+}
+
+// Authenticators builds the authenticators configured in cfg: API keys, then bearer tokens,
+// then anonymous access
+func Authenticators(cfg config.Auth) ([]Authenticator, error) {
+	var authenticators []Authenticator
+
+	if len(cfg.APIKeys) > 0 {
+		keys, err := NewAPIKeys(cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+
+	if cfg.JWT.Secret != "" || cfg.JWT.JWKSFile != "" {
+		tokens, err := NewJWT(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, tokens)
+	}
+
+	if cfg.Anonymous {
+		slog.Warn("requests without credentials are accepted as the default tenant")
+		authenticators = append(authenticators, Anonymous())
+	}
+
+	if len(authenticators) == 0 {
+		return nil, errors.New("no authentication configured, set auth.api_keys, auth.jwt or auth.anonymous")
+	}
+
+	return authenticators, nil
+}
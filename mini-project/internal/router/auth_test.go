@@ -0,0 +1,113 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"nats-project/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func apiKey(key, tenant string) config.APIKey {
+	sum := sha256.Sum256([]byte(key))
+	return config.APIKey{SHA256: hex.EncodeToString(sum[:]), Tenant: tenant, Subject: "key-of-" + tenant}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys, err := NewAPIKeys([]config.APIKey{apiKey("acme-key", "acme"), apiKey("globex-key", "globex")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := testJWT(t, config.JWT{Secret: testSecret})
+	valid := signToken(t, map[string]any{"alg": "HS256"}, claims(nil), hs256([]byte(testSecret)))
+	invalid := signToken(t, map[string]any{"alg": "HS256"}, claims(nil), hs256([]byte("another secret")))
+
+	tests := []struct {
+		name      string
+		anonymous bool
+		apiKey    string
+		token     string
+		want      int
+		wantBody  string
+	}{
+		{name: "api key", apiKey: "acme-key", want: http.StatusOK, wantBody: "acme/key-of-acme"},
+		{name: "api key of other tenant", apiKey: "globex-key", want: http.StatusOK, wantBody: "globex/key-of-globex"},
+		{name: "token", token: valid, want: http.StatusOK, wantBody: "acme/alice"},
+		{name: "api key before token", apiKey: "globex-key", token: valid, want: http.StatusOK, wantBody: "globex/key-of-globex"},
+		{name: "anonymous", anonymous: true, want: http.StatusOK, wantBody: "default/anonymous"},
+		{name: "no credentials", want: http.StatusUnauthorized},
+
+		// Invalid credentials are rejected, never retried with the next authenticator
+		{name: "unknown api key", anonymous: true, apiKey: "other-key", want: http.StatusUnauthorized},
+		{name: "unknown api key with valid token", apiKey: "other-key", token: valid, want: http.StatusUnauthorized},
+		{name: "invalid token", anonymous: true, token: invalid, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticators := []Authenticator{keys, tokens}
+			if tt.anonymous {
+				authenticators = append(authenticators, Anonymous())
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.apiKey != "" {
+				req.Header.Set(HeaderAPIKey, tt.apiKey)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := serve(req, principal, Authenticate(authenticators...))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("principal = %q, want %q", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeysInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []config.APIKey
+	}{
+		{name: "not a sha256", keys: []config.APIKey{{SHA256: "abc", Tenant: "acme"}}},
+		{name: "tenant that is no subject token", keys: []config.APIKey{apiKey("key", "acme.*")}},
+		{name: "duplicate key", keys: []config.APIKey{apiKey("key", "acme"), apiKey("key", "globex")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAPIKeys(tt.keys); err == nil {
+				t.Fatal("NewAPIKeys accepted the keys")
+			}
+		})
+	}
+}
+
+func TestRequireTenant(t *testing.T) {
+	tokens := testJWT(t, config.JWT{Secret: testSecret})
+
+	tests := []struct {
+		tenant string
+		want   int
+	}{
+		{tenant: "acme", want: http.StatusOK},
+		{tenant: "default", want: http.StatusOK},
+		{tenant: "globex", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			token := signToken(t, map[string]any{"alg": "HS256"}, claims(map[string]any{"tenant_id": tt.tenant}), hs256([]byte(testSecret)))
+			w := serve(bearer(token), principal, Authenticate(tokens), RequireTenant([]string{"default", "acme"}))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
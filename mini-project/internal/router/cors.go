@@ -0,0 +1,55 @@
+package router
+
+import (
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsAllowedHeaders are the request headers browsers may send to the API
+var corsAllowedHeaders = strings.Join([]string{
+	"Authorization", "Content-Type", "Idempotency-Key", HeaderAPIKey, logging.HeaderRequestID,
+}, ", ")
+
+// corsExposedHeaders are the response headers scripts may read
+var corsExposedHeaders = strings.Join([]string{
+	"Idempotent-Replayed", "Retry-After", logging.HeaderRequestID,
+}, ", ")
+
+// CORS lets browsers on the allowed origins call the API and answers their preflight requests.
+// Credentials are sent in headers rather than cookies, so credentialed requests are not allowed.
+func CORS(cfg config.CORS) gin.HandlerFunc {
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		if !anyOrigin && !slices.Contains(cfg.AllowedOrigins, origin) {
+			// Without the CORS headers the browser keeps the response from the script
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Expose-Headers", corsExposedHeaders)
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			c.Header("Access-Control-Allow-Headers", corsAllowedHeaders)
+			c.Header("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"nats-project/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	cfg := config.CORS{AllowedOrigins: []string{"https://shop.example"}, MaxAge: 10 * time.Minute}
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		preflight  bool
+		want       int
+		wantOrigin string
+	}{
+		{name: "no origin", method: http.MethodGet, want: http.StatusOK},
+		{name: "allowed origin", method: http.MethodGet, origin: "https://shop.example", want: http.StatusOK, wantOrigin: "https://shop.example"},
+		{name: "other origin", method: http.MethodGet, origin: "https://evil.example", want: http.StatusOK},
+		{name: "preflight", method: http.MethodOptions, origin: "https://shop.example", preflight: true, want: http.StatusNoContent, wantOrigin: "https://shop.example"},
+		{name: "preflight of other origin", method: http.MethodOptions, origin: "https://evil.example", preflight: true, want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/test", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+
+			w := serve(req, ok, CORS(cfg))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.wantOrigin != "" && tt.preflight {
+				if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+				}
+				if w.Header().Get("Access-Control-Allow-Headers") == "" {
+					t.Error("preflight response allows no headers")
+				}
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+
+	w := serve(req, ok, CORS(config.CORS{AllowedOrigins: []string{"*"}}))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://anywhere.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+}
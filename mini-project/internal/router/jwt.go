@@ -0,0 +1,287 @@
+package router
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"nats-project/internal/config"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// minRSABits rejects RSA keys too short to be trusted
+const minRSABits = 2048
+
+// JWT authenticates requests by a bearer token signed with HS256 or RS256. The alg of a
+// token only selects among keys of its own kind, so an RSA public key is never used as an HMAC secret.
+type JWT struct {
+	hmacKeys []hmacKey
+	rsaKeys  []rsaKey
+	// kids are the key ids in use, no two keys share one. Keys without a kid are all kept.
+	kids map[string]bool
+
+	issuer      string
+	audience    string
+	tenantClaim string
+	leeway      time.Duration
+	now         func() time.Time
+}
+
+type hmacKey struct {
+	kid    string
+	secret []byte
+}
+
+type rsaKey struct {
+	kid string
+	pub *rsa.PublicKey
+}
+
+func NewJWT(cfg config.JWT) (*JWT, error) {
+	j := &JWT{
+		kids:        map[string]bool{},
+		issuer:      cfg.Issuer,
+		audience:    cfg.Audience,
+		tenantClaim: cfg.TenantClaim,
+		leeway:      cfg.Leeway,
+		now:         time.Now,
+	}
+	if cfg.Secret != "" {
+		j.hmacKeys = append(j.hmacKeys, hmacKey{secret: []byte(cfg.Secret)})
+	}
+	if cfg.JWKSFile != "" {
+		if err := j.loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, fmt.Errorf("loading %s: %w", cfg.JWKSFile, err)
+		}
+	}
+	if len(j.hmacKeys) == 0 && len(j.rsaKeys) == 0 {
+		return nil, errors.New("jwt authentication has no keys")
+	}
+
+	return j, nil
+}
+
+// jwk is the subset of RFC 7517 used for RS256 and HS256 keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// loadJWKS reads the RSA and oct keys of a JWKS file. Encryption keys and keys of other
+// algorithms are skipped, so the file of an identity provider can be used as it is.
+func (j *JWT) loadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if j.kids[key.Kid] {
+			return fmt.Errorf("kid %q is used twice", key.Kid)
+		}
+
+		switch {
+		case key.Kty == "RSA" && (key.Alg == "" || key.Alg == "RS256"):
+			pub, err := key.rsaPublicKey()
+			if err != nil {
+				return fmt.Errorf("key %q: %w", key.Kid, err)
+			}
+			j.rsaKeys = append(j.rsaKeys, rsaKey{kid: key.Kid, pub: pub})
+		case key.Kty == "oct" && (key.Alg == "" || key.Alg == "HS256"):
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil || len(secret) == 0 {
+				return fmt.Errorf("key %q: invalid k", key.Kid)
+			}
+			j.hmacKeys = append(j.hmacKeys, hmacKey{kid: key.Kid, secret: secret})
+		default:
+			slog.Warn("skipping unsupported JWKS key", "kid", key.Kid, "kty", key.Kty, "alg", key.Alg)
+			continue
+		}
+		if key.Kid != "" {
+			j.kids[key.Kid] = true
+		}
+	}
+
+	return nil
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.New("invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("modulus is shorter than %d bits", minRSABits)
+	}
+
+	return pub, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Principal{}, ErrNoCredentials
+	}
+
+	claims, err := j.verify(strings.TrimSpace(token))
+	if err != nil {
+		return Principal{}, err
+	}
+
+	var p Principal
+	if err = json.Unmarshal(claims[j.tenantClaim], &p.Tenant); err != nil || p.Tenant == "" {
+		return Principal{}, fmt.Errorf("token has no %s claim", j.tenantClaim)
+	}
+	if err = json.Unmarshal(claims["sub"], &p.Subject); err != nil || p.Subject == "" {
+		return Principal{}, errors.New("token has no sub claim")
+	}
+
+	return p, nil
+}
+
+// verify checks the signature and the registered claims of a token and returns its claims
+func (j *JWT) verify(token string) (map[string]json.RawMessage, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a signed JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decoding token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding token signature: %w", err)
+	}
+	if !j.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature) {
+		return nil, fmt.Errorf("invalid %s signature with kid %q", header.Alg, header.Kid)
+	}
+
+	var claims map[string]json.RawMessage
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding token claims: %w", err)
+	}
+	var registered struct {
+		Issuer    string   `json:"iss"`
+		Audience  audience `json:"aud"`
+		ExpiresAt *float64 `json:"exp"`
+		NotBefore *float64 `json:"nbf"`
+	}
+	if err = decodeSegment(parts[1], &registered); err != nil {
+		return nil, fmt.Errorf("decoding token claims: %w", err)
+	}
+
+	now := j.now()
+	if registered.ExpiresAt == nil {
+		return nil, errors.New("token has no exp claim")
+	}
+	if now.After(unixTime(*registered.ExpiresAt).Add(j.leeway)) {
+		return nil, errors.New("token is expired")
+	}
+	if registered.NotBefore != nil && now.Before(unixTime(*registered.NotBefore).Add(-j.leeway)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if j.issuer != "" && registered.Issuer != j.issuer {
+		return nil, fmt.Errorf("token issuer %q is not accepted", registered.Issuer)
+	}
+	if j.audience != "" && !slices.Contains(registered.Audience, j.audience) {
+		return nil, errors.New("token is not meant for this audience")
+	}
+
+	return claims, nil
+}
+
+// verifySignature tries the key with the token's kid, or every key of the alg for tokens without a kid
+func (j *JWT) verifySignature(alg, kid, signed string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "HS256":
+		for _, key := range j.hmacKeys {
+			if kid != "" && key.kid != kid {
+				continue
+			}
+			mac := hmac.New(sha256.New, key.secret)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		}
+	case "RS256":
+		for _, key := range j.rsaKeys {
+			if kid != "" && key.kid != kid {
+				continue
+			}
+			if rsa.VerifyPKCS1v15(key.pub, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// audience is the aud claim, which is a single string or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("aud must be a string or a list of strings")
+	}
+	*a = list
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
@@ -0,0 +1,290 @@
+package router
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"nats-project/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSecret = "test-secret-of-at-least-thirty-two-bytes"
+
+var testNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+var (
+	rsaKeysOnce sync.Once
+	rsaKeys     [2]*rsa.PrivateKey
+)
+
+// testRSAKeys generates the RSA keys of the tests once, 2048 bit keys take a while
+func testRSAKeys(t *testing.T) [2]*rsa.PrivateKey {
+	t.Helper()
+	rsaKeysOnce.Do(func() {
+		for i := range rsaKeys {
+			key, err := rsa.GenerateKey(rand.Reader, minRSABits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsaKeys[i] = key
+		}
+	})
+	return rsaKeys
+}
+
+// signToken builds a token with header and claims, signed by sign
+func signToken(t *testing.T, header, claims map[string]any, sign func(signed string) []byte) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := segment(header) + "." + segment(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func hs256(secret []byte) func(string) []byte {
+	return func(signed string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey) func(string) []byte {
+	return func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func octJWK(kid string, secret []byte) map[string]any {
+	return map[string]any{
+		"kty": "oct",
+		"kid": kid,
+		"k":   base64.RawURLEncoding.EncodeToString(secret),
+	}
+}
+
+// writeJWKS writes keys to a JWKS file in a temporary directory
+func writeJWKS(t *testing.T, keys ...map[string]any) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testJWT(t *testing.T, cfg config.JWT) *JWT {
+	t.Helper()
+	if cfg.TenantClaim == "" {
+		cfg.TenantClaim = "tenant_id"
+	}
+	j, err := NewJWT(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.now = func() time.Time { return testNow }
+	return j
+}
+
+// claims returns valid claims of the acme tenant, with the entries of overrides replacing them.
+// A nil override removes the claim.
+func claims(overrides map[string]any) map[string]any {
+	c := map[string]any{
+		"iss":       "https://issuer.example",
+		"aud":       "orders",
+		"sub":       "alice",
+		"tenant_id": "acme",
+		"exp":       testNow.Add(time.Hour).Unix(),
+		"nbf":       testNow.Add(-time.Minute).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	return c
+}
+
+// principal answers with the tenant and subject of the caller
+func principal(c *gin.Context) {
+	p, _ := PrincipalFrom(c.Request.Context())
+	c.String(http.StatusOK, p.Tenant+"/"+p.Subject)
+}
+
+func bearer(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWT(t *testing.T) {
+	keys := testRSAKeys(t)
+	pubDER, err := x509.MarshalPKIXPublicKey(&keys[0].PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	j := testJWT(t, config.JWT{
+		Secret:   testSecret,
+		JWKSFile: writeJWKS(t, rsaJWK("rsa-1", &keys[0].PublicKey), octJWK("oct-1", []byte("jwks-oct-secret"))),
+		Issuer:   "https://issuer.example",
+		Audience: "orders",
+		Leeway:   30 * time.Second,
+	})
+
+	hs := map[string]any{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]any{"alg": "RS256", "typ": "JWT", "kid": "rsa-1"}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "hs256 secret", token: signToken(t, hs, claims(nil), hs256([]byte(testSecret))), want: http.StatusOK},
+		{name: "hs256 jwks key", token: signToken(t, map[string]any{"alg": "HS256", "kid": "oct-1"}, claims(nil), hs256([]byte("jwks-oct-secret"))), want: http.StatusOK},
+		{name: "rs256", token: signToken(t, rs, claims(nil), rs256(t, keys[0])), want: http.StatusOK},
+		{name: "rs256 without kid", token: signToken(t, map[string]any{"alg": "RS256"}, claims(nil), rs256(t, keys[0])), want: http.StatusOK},
+		{name: "audience list", token: signToken(t, hs, claims(map[string]any{"aud": []string{"billing", "orders"}}), hs256([]byte(testSecret))), want: http.StatusOK},
+		{name: "expired within leeway", token: signToken(t, hs, claims(map[string]any{"exp": testNow.Add(-20 * time.Second).Unix()}), hs256([]byte(testSecret))), want: http.StatusOK},
+
+		{name: "wrong secret", token: signToken(t, hs, claims(nil), hs256([]byte("another secret"))), want: http.StatusUnauthorized},
+		{name: "tampered claims", token: tamper(t, signToken(t, hs, claims(nil), hs256([]byte(testSecret)))), want: http.StatusUnauthorized},
+		{name: "rs256 signed by unknown key", token: signToken(t, rs, claims(nil), rs256(t, keys[1])), want: http.StatusUnauthorized},
+		{name: "unknown kid", token: signToken(t, map[string]any{"alg": "RS256", "kid": "rsa-2"}, claims(nil), rs256(t, keys[0])), want: http.StatusUnauthorized},
+		{name: "kid of other alg", token: signToken(t, map[string]any{"alg": "HS256", "kid": "rsa-1"}, claims(nil), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "alg confusion with public key pem", token: signToken(t, map[string]any{"alg": "HS256", "kid": "rsa-1"}, claims(nil), hs256(pubPEM)), want: http.StatusUnauthorized},
+		{name: "alg confusion with public key der", token: signToken(t, hs, claims(nil), hs256(pubDER)), want: http.StatusUnauthorized},
+		{name: "alg none", token: signToken(t, map[string]any{"alg": "none"}, claims(nil), func(string) []byte { return nil }), want: http.StatusUnauthorized},
+		{name: "alg hs512", token: signToken(t, map[string]any{"alg": "HS512"}, claims(nil), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "expired", token: signToken(t, hs, claims(map[string]any{"exp": testNow.Add(-time.Minute).Unix()}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "no exp", token: signToken(t, hs, claims(map[string]any{"exp": nil}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "not valid yet", token: signToken(t, hs, claims(map[string]any{"nbf": testNow.Add(time.Minute).Unix()}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "wrong audience", token: signToken(t, hs, claims(map[string]any{"aud": "billing"}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "wrong audience list", token: signToken(t, hs, claims(map[string]any{"aud": []string{"billing"}}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "no audience", token: signToken(t, hs, claims(map[string]any{"aud": nil}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "wrong issuer", token: signToken(t, hs, claims(map[string]any{"iss": "https://evil.example"}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "no tenant", token: signToken(t, hs, claims(map[string]any{"tenant_id": nil}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "tenant that is no subject token", token: signToken(t, hs, claims(map[string]any{"tenant_id": "acme.*"}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "no subject", token: signToken(t, hs, claims(map[string]any{"sub": nil}), hs256([]byte(testSecret))), want: http.StatusUnauthorized},
+		{name: "not a jwt", token: "not-a-token", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(bearer(tt.token), principal, Authenticate(j))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusOK && w.Body.String() != "acme/alice" {
+				t.Errorf("principal = %q, want acme/alice", w.Body)
+			}
+			if tt.want == http.StatusUnauthorized && !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+				t.Errorf("WWW-Authenticate = %q, want invalid_token", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// tamper replaces the claims of a signed token with claims of another tenant
+func tamper(t *testing.T, token string) string {
+	parts := strings.Split(token, ".")
+	data, err := json.Marshal(claims(map[string]any{"tenant_id": "globex"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString(data)
+	return strings.Join(parts, ".")
+}
+
+// Keys without a kid are all tried, so rotating to a new key keeps tokens of the old one valid
+func TestJWTKeysWithoutKid(t *testing.T) {
+	keys := testRSAKeys(t)
+	j := testJWT(t, config.JWT{
+		Secret:   testSecret,
+		JWKSFile: writeJWKS(t, rsaJWK("", &keys[0].PublicKey), rsaJWK("", &keys[1].PublicKey), octJWK("", []byte("jwks-oct-secret"))),
+	})
+
+	tokens := map[string]string{
+		"first rsa key":  signToken(t, map[string]any{"alg": "RS256"}, claims(nil), rs256(t, keys[0])),
+		"second rsa key": signToken(t, map[string]any{"alg": "RS256"}, claims(nil), rs256(t, keys[1])),
+		"secret":         signToken(t, map[string]any{"alg": "HS256"}, claims(nil), hs256([]byte(testSecret))),
+		"jwks oct key":   signToken(t, map[string]any{"alg": "HS256"}, claims(nil), hs256([]byte("jwks-oct-secret"))),
+	}
+	for name, token := range tokens {
+		if w := serve(bearer(token), principal, Authenticate(j)); w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestNewJWTInvalidKeys(t *testing.T) {
+	keys := testRSAKeys(t)
+	short, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		keys []map[string]any
+	}{
+		{name: "duplicate kid", keys: []map[string]any{rsaJWK("k", &keys[0].PublicKey), rsaJWK("k", &keys[1].PublicKey)}},
+		{name: "kid of rsa and oct key", keys: []map[string]any{rsaJWK("k", &keys[0].PublicKey), octJWK("k", []byte("secret"))}},
+		{name: "short rsa key", keys: []map[string]any{rsaJWK("k", &short.PublicKey)}},
+		{name: "empty oct key", keys: []map[string]any{octJWK("k", nil)}},
+		{name: "only unsupported keys", keys: []map[string]any{{"kty": "EC", "kid": "k", "crv": "P-256"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewJWT(config.JWT{JWKSFile: writeJWKS(t, tt.keys...), TenantClaim: "tenant_id"}); err == nil {
+				t.Fatal("NewJWT accepted the keys")
+			}
+		})
+	}
+}
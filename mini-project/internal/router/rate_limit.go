@@ -0,0 +1,100 @@
+package router
+
+import (
+	"math"
+	"nats-project/internal/config"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sweepInterval is how often buckets of clients that went quiet are dropped
+const sweepInterval = time.Minute
+
+// RateLimit rejects requests of clients that ran out of tokens with 429 and a Retry-After header.
+// Every client IP has a bucket of cfg.Burst tokens refilled with cfg.RPS tokens a second.
+func RateLimit(cfg config.RateLimit) gin.HandlerFunc {
+	l := newLimiter(cfg.RPS, cfg.Burst, time.Now)
+
+	return func(c *gin.Context) {
+		ok, wait := l.allow(c.ClientIP())
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// bucket holds the tokens of one client as of last
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a token bucket per client
+type limiter struct {
+	rps   float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newLimiter(rps float64, burst int, now func() time.Time) *limiter {
+	return &limiter{
+		rps:       rps,
+		burst:     float64(burst),
+		now:       now,
+		buckets:   map[string]*bucket{},
+		lastSweep: now(),
+	}
+}
+
+// allow takes a token of the client, or returns how long until the client has one again
+func (l *limiter) allow(client string) (bool, time.Duration) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+func (l *limiter) refill(b *bucket, now time.Time) float64 {
+	return min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+}
+
+// sweep drops the buckets that filled up again, a full bucket is the same as no bucket
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for client, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
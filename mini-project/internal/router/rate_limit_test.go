@@ -0,0 +1,71 @@
+package router
+
+import (
+	"nats-project/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	handler := RateLimit(config.RateLimit{RPS: 1, Burst: 2})
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = remoteAddr
+		return serve(req, ok, handler)
+	}
+
+	for i := range 2 {
+		if w := send("10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+	w := send("10.0.0.1:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+
+	// Every client has its own bucket
+	if w := send("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("other client status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newLimiter(2, 1, func() time.Time { return now })
+
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("first request rejected")
+	}
+	ok, wait := l.allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("allow = %v, %v, want false, 500ms", ok, wait)
+	}
+
+	now = now.Add(wait)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("request after the refill rejected")
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newLimiter(1, 5, func() time.Time { return now })
+
+	l.allow("idle")
+	now = now.Add(sweepInterval)
+	l.allow("busy")
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("bucket of an idle client was not swept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket of an active client was swept")
+	}
+}
@@ -0,0 +1,28 @@
+package router
+
+import (
+	"nats-project/internal/logging"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nuid"
+)
+
+// requestIDRegex keeps request ids sent by callers short and safe to log and to put in message headers
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID gives every request an id, echoes it in the X-Request-ID response header and stores
+// it with a logger tagged with it in the request context. With trust the caller's X-Request-ID is
+// reused when it is well formed, so a request can be followed across services.
+func RequestID(trust bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(logging.HeaderRequestID)
+		if !trust || !requestIDRegex.MatchString(id) {
+			id = nuid.Next()
+		}
+		c.Header(logging.HeaderRequestID, id)
+
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
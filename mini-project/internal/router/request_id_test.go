@@ -0,0 +1,48 @@
+package router
+
+import (
+	"nats-project/internal/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		trust    bool
+		incoming string
+		reused   bool
+	}{
+		{name: "generated", trust: true},
+		{name: "reused", trust: true, incoming: "abc-123.x_y", reused: true},
+		{name: "malformed", trust: true, incoming: "bad id\n"},
+		{name: "too long", trust: true, incoming: strings.Repeat("a", 129)},
+		{name: "untrusted", trust: false, incoming: "abc-123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.incoming != "" {
+				req.Header.Set(logging.HeaderRequestID, tt.incoming)
+			}
+
+			var seen string
+			w := serve(req, func(c *gin.Context) {
+				seen = logging.RequestID(c.Request.Context())
+			}, RequestID(tt.trust))
+
+			id := w.Header().Get(logging.HeaderRequestID)
+			if id == "" || id != seen {
+				t.Fatalf("response id %q, context id %q, want the same non-empty id", id, seen)
+			}
+			if reused := id == tt.incoming; reused != tt.reused {
+				t.Errorf("id %q reused = %v, want %v", id, reused, tt.reused)
+			}
+		})
+	}
+}
@@ -1,14 +1,39 @@
 package router
 
 import (
+	"nats-project/internal/config"
+
 	"github.com/gin-gonic/gin"
 )
 
-// NewGinRouter create a new gin router for each micro service
-func NewGinRouter() (router *gin.Engine) {
-	router = gin.New()
-	
+// NewGinRouter creates the gin router of an HTTP service with the standard middleware:
+// request ids, access logs, panic recovery, CORS, rate limits, body limits and timeouts.
+// Each piece is configured by cfg and left out when it is disabled there. The middleware of
+// the service, such as tracing and metrics, runs before the limits so it sees rejected requests too.
+func NewGinRouter(cfg config.HTTP, middleware ...gin.HandlerFunc) (*gin.Engine, error) {
+	router := gin.New()
+
+	// Without trusted proxies ClientIP is the address of the connection, which callers cannot forge
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+
+	router.Use(RequestID(cfg.TrustRequestID))
+	if cfg.AccessLog {
+		router.Use(AccessLog())
+	}
 	router.Use(gin.Recovery())
+	router.Use(middleware...)
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		router.Use(CORS(cfg.CORS))
+	}
+	if cfg.RateLimit.RPS > 0 {
+		router.Use(RateLimit(cfg.RateLimit))
+	}
+	if cfg.MaxBodyBytes > 0 {
+		router.Use(BodyLimit(cfg.MaxBodyBytes))
+	}
+	router.Use(Timeout(cfg.Timeout, cfg.RouteTimeouts))
 
-	return router
+	return router, nil
 }
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// serve sends req through a router running middleware in front of handler on GET and POST /test
+func serve(req *http.Request, handler gin.HandlerFunc, middleware ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Use(middleware...)
+	r.GET("/test", handler)
+	r.POST("/test", handler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func ok(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+func TestNewGinRouter(t *testing.T) {
+	cfg := config.Default().HTTP
+	cfg.RateLimit = config.RateLimit{RPS: 1, Burst: 1}
+	r, err := NewGinRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/test", ok)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Header().Get(logging.HeaderRequestID) == "" {
+		t.Error("response has no request id")
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("second request status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestNewGinRouterInvalidTrustedProxy(t *testing.T) {
+	cfg := config.Default().HTTP
+	cfg.TrustedProxies = []string{"not an address"}
+	if _, err := NewGinRouter(cfg); err == nil {
+		t.Fatal("NewGinRouter accepted an invalid trusted proxy")
+	}
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTimeout(t *testing.T) {
+	// wait blocks until the request context ends or 100ms pass
+	wait := func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+			c.String(http.StatusOK, "done")
+		}
+	}
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		routes  map[string]time.Duration
+		want    int
+	}{
+		{name: "default timeout", timeout: 10 * time.Millisecond, want: http.StatusServiceUnavailable},
+		{name: "route timeout", timeout: time.Hour, routes: map[string]time.Duration{"GET /test": 10 * time.Millisecond}, want: http.StatusServiceUnavailable},
+		{name: "route without timeout", timeout: 10 * time.Millisecond, routes: map[string]time.Duration{"GET /test": 0}, want: http.StatusOK},
+		{name: "other method", timeout: 0, routes: map[string]time.Duration{"POST /test": 10 * time.Millisecond}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if w := serve(req, wait, Timeout(tt.timeout, tt.routes)); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutKeepsWrittenResponse(t *testing.T) {
+	// A handler that answered before noticing the deadline keeps its response
+	slow := func(c *gin.Context) {
+		c.String(http.StatusAccepted, "accepted")
+		<-c.Request.Context().Done()
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	w := serve(req, slow, Timeout(10*time.Millisecond, nil))
+	if w.Code != http.StatusAccepted || w.Body.String() != "accepted" {
+		t.Errorf("response = %d %q, want %d %q", w.Code, w.Body.String(), http.StatusAccepted, "accepted")
+	}
+}
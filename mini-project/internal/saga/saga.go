@@ -0,0 +1,269 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"nats-project/internal/outbox"
+	"nats-project/internal/retry"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// eventSource is the CloudEvents source of the commands sent by the saga
+const eventSource = "saga"
+
+// State is the step an order fulfillment saga is at
+type State string
+
+const (
+	// Reserving waits for the inventory service to reserve the item
+	Reserving State = "RESERVING"
+	// Charging waits for the payment service to charge the amount
+	Charging State = "CHARGING"
+	// Compensating waits for the inventory service to release the item after a failed payment,
+	// or after the dead-letter queue failed the order
+	Compensating State = "COMPENSATING"
+	Completed    State = "COMPLETED"
+	Failed       State = "FAILED"
+)
+
+var ErrNotFound = errors.New("saga not found")
+
+// Saga is a row of the order_sagas table. It keeps what later steps need from the order
+// created event, so commands can be sent without reading the order again.
+type Saga struct {
+	OrderID string
+	Item    string
+	Amount  float64
+	State   State
+	Reason  string
+}
+
+// Start begins the saga of an order as part of the caller's transaction, which also moves
+// the order to PROCESSING. Starting the saga of an order twice is a no-op.
+func Start(ctx context.Context, tx pgx.Tx, created events.OrderCreated) error {
+	tag, err := tx.Exec(ctx, `INSERT INTO order_sagas (order_id, item, amount, state) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (order_id) DO NOTHING`, created.OrderID, created.Item, created.Amount, Reserving)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	tenant := order.TenantOrDefault(created.TenantID)
+	return send(ctx, tx, tenant, created.OrderID, events.ReserveInventory{OrderID: created.OrderID, Item: created.Item})
+}
+
+// Coordinator drives each saga forward on the outcome events of the inventory and payment services.
+// All state lives in postgres and every step commits together with the command it sends,
+// so a restarted coordinator continues where it stopped.
+type Coordinator struct {
+	pgxPool *pgxpool.Pool
+}
+
+func NewCoordinator(pgxPool *pgxpool.Pool) *Coordinator {
+	return &Coordinator{pgxPool: pgxPool}
+}
+
+// Handle applies one outcome event. Events that do not match the current state of their saga,
+// such as redeliveries of an applied step, are acked without changing anything.
+func (c *Coordinator) Handle(ctx context.Context, msg jetstream.Msg) error {
+	env, err := events.Decode(msg.Subject(), msg.Headers(), msg.Data())
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	// Commands go to the tenant of the outcome event
+	tenant := events.TenantOf(msg.Subject())
+
+	switch env.Type {
+	case events.TypeInventoryReserved:
+		var e events.InventoryReserved
+		if err := env.Unmarshal(&e); err != nil {
+			return retry.Permanent(err)
+		}
+		return c.advance(ctx, tenant, e.OrderID, Reserving, Charging, "")
+	case events.TypeReservationFailed:
+		var e events.ReservationFailed
+		if err := env.Unmarshal(&e); err != nil {
+			return retry.Permanent(err)
+		}
+		return c.advance(ctx, tenant, e.OrderID, Reserving, Failed, e.Reason)
+	case events.TypePaymentSucceeded:
+		var e events.PaymentSucceeded
+		if err := env.Unmarshal(&e); err != nil {
+			return retry.Permanent(err)
+		}
+		return c.advance(ctx, tenant, e.OrderID, Charging, Completed, "")
+	case events.TypePaymentFailed:
+		var e events.PaymentFailed
+		if err := env.Unmarshal(&e); err != nil {
+			return retry.Permanent(err)
+		}
+		return c.advance(ctx, tenant, e.OrderID, Charging, Compensating, e.Reason)
+	case events.TypeInventoryReleased:
+		var e events.InventoryReleased
+		if err := env.Unmarshal(&e); err != nil {
+			return retry.Permanent(err)
+		}
+		return c.advance(ctx, tenant, e.OrderID, Compensating, Failed, "")
+	default:
+		return retry.Permanent(fmt.Errorf("unexpected event type %s", env.Type))
+	}
+}
+
+// advance moves a saga from one state to the next and applies the effect of entering it:
+// sending the next command or finishing the order. The saga row is locked for the
+// whole step, so concurrent deliveries of the same event cannot both apply it.
+func (c *Coordinator) advance(ctx context.Context, tenant, orderID string, from, to State, reason string) error {
+	ctx, logger := logging.With(ctx, logging.KeyOrderID, orderID)
+
+	tx, err := c.pgxPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var s Saga
+	err = tx.QueryRow(ctx, "SELECT order_id, item, amount, state, reason FROM order_sagas WHERE order_id = $1 FOR UPDATE", orderID).
+		Scan(&s.OrderID, &s.Item, &s.Amount, &s.State, &s.Reason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.Warn("event refers to unknown saga")
+		return retry.Permanent(ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.State != from {
+		logger.Info("ignoring event, saga already moved on", "state", s.State, "expected", from)
+		return nil
+	}
+
+	// The dead-letter queue may have failed the order while this step was on its way, the
+	// saga then compensates instead of charging or completing
+	if to == Charging || to == Completed {
+		status, _, err := order.CurrentStatus(ctx, tx, "", orderID)
+		if err != nil {
+			return err
+		}
+		if status == order.Failed {
+			if err = abort(ctx, tx, tenant, s, "order failed while its saga was running"); err != nil {
+				return err
+			}
+			if err = tx.Commit(ctx); err != nil {
+				return err
+			}
+			logger.Info("saga aborted, order already FAILED", "state", s.State)
+			return nil
+		}
+	}
+
+	// A compensated saga keeps the reason of the failure that started the compensation
+	if reason == "" {
+		reason = s.Reason
+	}
+	_, err = tx.Exec(ctx, "UPDATE order_sagas SET state = $1, reason = $2, updated_at = now() WHERE order_id = $3",
+		to, reason, orderID)
+	if err != nil {
+		return err
+	}
+
+	switch to {
+	case Charging:
+		err = send(ctx, tx, tenant, orderID, events.ChargePayment{OrderID: orderID, Amount: s.Amount})
+	case Compensating:
+		err = send(ctx, tx, tenant, orderID, events.ReleaseInventory{OrderID: orderID, Item: s.Item})
+	case Completed:
+		err = finish(ctx, tx, orderID, order.Completed)
+	case Failed:
+		err = finish(ctx, tx, orderID, order.Failed)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	logger.Info("saga advanced", "from", from, "to", to, "reason", reason)
+	return nil
+}
+
+// finish moves the order out of PROCESSING once its saga ended
+func finish(ctx context.Context, tx pgx.Tx, orderID string, status order.Status) error {
+	err := order.Transition(ctx, tx, orderID, order.Processing, status)
+
+	var transitionErr *order.TransitionError
+	switch {
+	case errors.As(err, &transitionErr) && transitionErr.Current == status:
+		// The dead-letter queue failed the order and aborted its saga, which has now compensated
+		return nil
+	case errors.As(err, &transitionErr) && transitionErr.Current != "":
+		logging.FromContext(ctx).Warn("order left PROCESSING before its saga ended", logging.KeyError, err)
+		return nil
+	}
+
+	return err
+}
+
+// Abort compensates the saga of an order the dead-letter queue failed, as part of the caller's
+// transaction that also fails the order. The reserved item is released and a charge that may have
+// been made is refunded, the saga then ends FAILED once the release is confirmed. Sagas that
+// already ended, and orders without a saga, are left alone.
+func Abort(ctx context.Context, tx pgx.Tx, tenant, orderID, reason string) error {
+	var s Saga
+	err := tx.QueryRow(ctx, "SELECT order_id, item, amount, state, reason FROM order_sagas WHERE order_id = $1 FOR UPDATE", orderID).
+		Scan(&s.OrderID, &s.Item, &s.Amount, &s.State, &s.Reason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return abort(ctx, tx, tenant, s, reason)
+}
+
+// abort moves a locked saga to COMPENSATING and sends the commands undoing its steps. A saga
+// already compensating gets its release again, in case that command was the one dead-lettered.
+func abort(ctx context.Context, tx pgx.Tx, tenant string, s Saga, reason string) error {
+	switch s.State {
+	case Completed, Failed:
+		return nil
+	case Compensating:
+		reason = s.Reason
+	}
+
+	_, err := tx.Exec(ctx, "UPDATE order_sagas SET state = $1, reason = $2, updated_at = now() WHERE order_id = $3",
+		Compensating, reason, s.OrderID)
+	if err != nil {
+		return err
+	}
+
+	// A charge command is on its way or was applied, the refund covers both
+	if s.State == Charging {
+		if err = send(ctx, tx, tenant, s.OrderID, events.RefundPayment{OrderID: s.OrderID, Amount: s.Amount}); err != nil {
+			return err
+		}
+	}
+
+	return send(ctx, tx, tenant, s.OrderID, events.ReleaseInventory{OrderID: s.OrderID, Item: s.Item})
+}
+
+// send stores a saga command for tenant in the outbox of the current transaction
+func send(ctx context.Context, tx pgx.Tx, tenant, orderID string, command events.Event) error {
+	env, err := events.New(eventSource, orderID, command)
+	if err != nil {
+		return err
+	}
+
+	return outbox.Insert(ctx, tx, events.Subject(tenant, command.EventType()), env)
+}
@@ -0,0 +1,243 @@
+package statuscache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"nats-project/internal/retry"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Bucket is the KeyValue bucket holding the latest status of every order, keyed by order id
+const Bucket = "ORDER_STATUS"
+
+// ConsumerName is the durable consumer of the order history that feeds the bucket
+const ConsumerName = "STATUS_CACHE"
+
+// history is the number of statuses the bucket keeps per order
+const history = 5
+
+// ConsumerConfig returns the consumer of the order history stream that feeds the bucket
+func ConsumerConfig(policies retry.Policies) jetstream.ConsumerConfig {
+	return jetstream.ConsumerConfig{
+		Durable:       ConsumerName,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		FilterSubject: events.HistorySubjectPrefix + ">",
+		MaxAckPending: 20,
+		AckWait:       retry.AckWait,
+		MaxDeliver:    policies.For(events.HistorySubjectPrefix + ">").MaxDeliver,
+		ReplayPolicy:  jetstream.ReplayInstantPolicy,
+	}
+}
+
+// BucketConfig returns the order status bucket
+func BucketConfig() jetstream.KeyValueConfig {
+	return jetstream.KeyValueConfig{
+		Bucket:      Bucket,
+		Description: "Latest status of every order, keyed by order id",
+		History:     history,
+		Storage:     jetstream.FileStorage,
+	}
+}
+
+// BucketStreamConfig returns the stream backing the bucket, with the settings JetStream
+// derives from BucketConfig. services/topology reconciles it like any other stream.
+func BucketStreamConfig() jetstream.StreamConfig {
+	bucket := BucketConfig()
+	return jetstream.StreamConfig{
+		Name:              "KV_" + bucket.Bucket,
+		Description:       bucket.Description,
+		Subjects:          []string{"$KV." + bucket.Bucket + ".>"},
+		Storage:           bucket.Storage,
+		Retention:         jetstream.LimitsPolicy,
+		Discard:           jetstream.DiscardNew,
+		MaxMsgsPerSubject: int64(bucket.History),
+		Duplicates:        2 * time.Minute,
+		AllowRollup:       true,
+		DenyDelete:        true,
+		AllowDirect:       true,
+	}
+}
+
+// casAttempts bounds how often a write is retried when another writer changed the key in between
+const casAttempts = 3
+
+// ErrNotFound is returned for orders the cache has no status for yet
+var ErrNotFound = errors.New("order status not cached")
+
+// Entry is the cached status of an order. The version is the order version that produced it,
+// older versions never overwrite newer ones.
+type Entry struct {
+	OrderID  string       `json:"-"`
+	TenantID string       `json:"tenant_id"`
+	Status   order.Status `json:"status"`
+	Version  int64        `json:"version"`
+}
+
+// Cache reads and writes the order status bucket. It is fed from the ORDERS_HISTORY stream,
+// so it trails postgres by the outbox relay delay and readers fall back to postgres on a miss.
+type Cache struct {
+	kv jetstream.KeyValue
+}
+
+// New opens the order status bucket, which stream-init creates
+func New(ctx context.Context, js jetstream.JetStream) (*Cache, error) {
+	kv, err := js.KeyValue(ctx, Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s bucket: %w", Bucket, err)
+	}
+
+	return &Cache{kv: kv}, nil
+}
+
+// Get returns the cached status of an order
+func (c *Cache) Get(ctx context.Context, orderID string) (Entry, error) {
+	kve, err := c.kv.Get(ctx, orderID)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return Entry{}, ErrNotFound
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return decode(kve)
+}
+
+// Set stores the status of an order unless the cache already holds the same or a newer version.
+// Writes are compare-and-set on the key revision, so concurrent writers cannot go back in time.
+func (c *Cache) Set(ctx context.Context, e Entry) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for range casAttempts {
+		kve, err := c.kv.Get(ctx, e.OrderID)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			if _, err = c.kv.Create(ctx, e.OrderID, value); errors.Is(err, jetstream.ErrKeyExists) {
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		current, err := decode(kve)
+		if err != nil {
+			return err
+		}
+		if current.Version >= e.Version {
+			return nil
+		}
+
+		if _, err = c.kv.Update(ctx, e.OrderID, value, kve.Revision()); err == nil {
+			return nil
+		}
+		var apiErr *jetstream.APIError
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode != jetstream.JSErrCodeStreamWrongLastSequence {
+			return err
+		}
+	}
+
+	return fmt.Errorf("order %s status changed concurrently %d times", e.OrderID, casAttempts)
+}
+
+// Handle applies an order history event to the cache, it is meant for a consumer of ORDERS_HISTORY
+func (c *Cache) Handle(ctx context.Context, msg jetstream.Msg) error {
+	env, err := events.Decode(msg.Subject(), msg.Headers(), msg.Data())
+	if err != nil {
+		return retry.Permanent(err)
+	}
+
+	e, ok, err := FromHistory(env)
+	if err != nil {
+		return retry.Permanent(err)
+	}
+	if !ok {
+		logging.FromContext(ctx).Debug("skipping history event without a status", "type", env.Type)
+		return nil
+	}
+
+	return c.Set(ctx, e)
+}
+
+// FromHistory returns the status an order history event leaves the order in,
+// ok is false for event types that do not carry a status
+func FromHistory(env events.Envelope) (e Entry, ok bool, err error) {
+	switch env.Type {
+	case events.TypeOrderCreated:
+		var created events.OrderCreated
+		err = env.Unmarshal(&created)
+		e = Entry{OrderID: created.OrderID, TenantID: created.TenantID, Status: order.Pending, Version: 1}
+	case events.TypeOrderStatusChanged:
+		var changed events.OrderStatusChanged
+		err = env.Unmarshal(&changed)
+		e = Entry{OrderID: changed.OrderID, TenantID: changed.TenantID, Status: order.Status(changed.Status), Version: changed.Version}
+	case events.TypeOrderSnapshot:
+		var snapshot events.OrderSnapshot
+		err = env.Unmarshal(&snapshot)
+		e = Entry{OrderID: snapshot.OrderID, TenantID: snapshot.TenantID, Status: order.Status(snapshot.Status), Version: snapshot.Version}
+	default:
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	e.TenantID = order.TenantOrDefault(e.TenantID)
+
+	return e, true, nil
+}
+
+// Watch sends every status change of the order to fn until ctx is cancelled, or of every order
+// when orderID is empty. Only changes after the call are sent, not the current status.
+func (c *Cache) Watch(ctx context.Context, orderID string, fn func(Entry)) error {
+	key := orderID
+	if key == "" {
+		key = "*"
+	}
+
+	watcher, err := c.kv.Watch(ctx, key, jetstream.UpdatesOnly())
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case kve, ok := <-watcher.Updates():
+			if !ok {
+				return nil
+			}
+			if kve == nil || kve.Operation() != jetstream.KeyValuePut {
+				continue
+			}
+
+			e, err := decode(kve)
+			if err != nil {
+				logging.FromContext(ctx).Warn("skipping undecodable order status", logging.KeyOrderID, kve.Key(), logging.KeyError, err)
+				continue
+			}
+			fn(e)
+		}
+	}
+}
+
+func decode(kve jetstream.KeyValueEntry) (Entry, error) {
+	var e Entry
+	if err := json.Unmarshal(kve.Value(), &e); err != nil {
+		return Entry{}, fmt.Errorf("decoding status of order %s: %w", kve.Key(), err)
+	}
+	e.OrderID = kve.Key()
+	e.TenantID = order.TenantOrDefault(e.TenantID)
+
+	return e, nil
+}
@@ -0,0 +1,46 @@
+package topology
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrBlocked is returned by Apply when some changes cannot be made in place
+var ErrBlocked = errors.New("changes cannot be applied in place")
+
+// Apply executes the planned changes in order. Blocked changes are skipped and reported
+// with ErrBlocked once every other change has been applied.
+func Apply(ctx context.Context, js jetstream.JetStream, changes []Change) error {
+	var blocked []string
+
+	for _, c := range changes {
+		if c.Blocked() {
+			blocked = append(blocked, c.target())
+			continue
+		}
+
+		var err error
+		switch {
+		case c.Consumer == "" && c.Action == Delete:
+			err = js.DeleteStream(ctx, c.Stream)
+		case c.Consumer == "":
+			_, err = js.CreateOrUpdateStream(ctx, c.streamConfig)
+		case c.Action == Delete:
+			err = js.DeleteConsumer(ctx, c.Stream, c.Consumer)
+		default:
+			_, err = js.CreateOrUpdateConsumer(ctx, c.Stream, c.consumerConfig)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", c.Action, c.target(), err)
+		}
+	}
+
+	if len(blocked) > 0 {
+		return fmt.Errorf("%w: %v", ErrBlocked, blocked)
+	}
+
+	return nil
+}
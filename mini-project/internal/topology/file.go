@@ -0,0 +1,245 @@
+package topology
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// durationFields are the config keys holding a time.Duration. In the file they are written
+// as Go duration strings ("30m"), JetStream itself encodes them as nanoseconds.
+var durationFields = map[string]bool{
+	"max_age":                   true,
+	"duplicate_window":          true,
+	"subject_delete_marker_ttl": true,
+	"ack_wait":                  true,
+	"backoff":                   true,
+	"max_expires":               true,
+	"inactive_threshold":        true,
+	"priority_timeout":          true,
+	"idle_heartbeat":            true,
+}
+
+// Stream is the desired state of one stream and its consumers
+type Stream struct {
+	Config    jetstream.StreamConfig
+	Consumers []Consumer
+
+	// fields holds the keys set in the file or by NewStream, only those are compared with the server
+	fields map[string]any
+}
+
+// Consumer is the desired state of one consumer
+type Consumer struct {
+	Config jetstream.ConsumerConfig
+
+	fields map[string]any
+}
+
+// Name returns the durable name of the consumer, falling back to its name
+func (c Consumer) Name() string {
+	if c.Config.Durable != "" {
+		return c.Config.Durable
+	}
+	return c.Config.Name
+}
+
+// Load reads the desired topology from a YAML or JSON file. Every stream entry uses the
+// JSON field names of jetstream.StreamConfig plus a consumers list of jetstream.ConsumerConfig.
+func Load(path string) ([]Stream, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so one parser covers both formats
+	var file struct {
+		Streams []map[string]any `yaml:"streams"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	streams := make([]Stream, 0, len(file.Streams))
+	seen := map[string]bool{}
+	for i, raw := range file.Streams {
+		rawConsumers, _ := raw["consumers"].([]any)
+		delete(raw, "consumers")
+
+		var s Stream
+		if s.fields, err = decode(raw, &s.Config); err != nil {
+			return nil, fmt.Errorf("stream %d: %w", i, err)
+		}
+		if s.Config.Name == "" {
+			return nil, fmt.Errorf("stream %d: name is required", i)
+		}
+		if seen[s.Config.Name] {
+			return nil, fmt.Errorf("stream %s is declared twice", s.Config.Name)
+		}
+		seen[s.Config.Name] = true
+
+		consumerSeen := map[string]bool{}
+		for j, rc := range rawConsumers {
+			rawConsumer, ok := rc.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("stream %s: consumer %d is not an object", s.Config.Name, j)
+			}
+
+			var c Consumer
+			if c.fields, err = decode(rawConsumer, &c.Config); err != nil {
+				return nil, fmt.Errorf("stream %s: consumer %d: %w", s.Config.Name, j, err)
+			}
+			if c.Name() == "" {
+				return nil, fmt.Errorf("stream %s: consumer %d: durable_name or name is required", s.Config.Name, j)
+			}
+			if consumerSeen[c.Name()] {
+				return nil, fmt.Errorf("stream %s: consumer %s is declared twice", s.Config.Name, c.Name())
+			}
+			consumerSeen[c.Name()] = true
+			s.Consumers = append(s.Consumers, c)
+		}
+
+		streams = append(streams, s)
+	}
+
+	return streams, nil
+}
+
+// NewStream describes a stream built in code instead of read from a file. The non-zero fields
+// of the configs are the ones compared with the server.
+func NewStream(config jetstream.StreamConfig, consumers ...jetstream.ConsumerConfig) (Stream, error) {
+	s := Stream{Config: config}
+
+	var err error
+	if s.fields, err = setFields(config); err != nil {
+		return Stream{}, fmt.Errorf("stream %s: %w", config.Name, err)
+	}
+
+	for _, cc := range consumers {
+		c := Consumer{Config: cc}
+		if c.fields, err = setFields(cc); err != nil {
+			return Stream{}, fmt.Errorf("stream %s: consumer %s: %w", config.Name, c.Name(), err)
+		}
+		s.Consumers = append(s.Consumers, c)
+	}
+
+	return s, nil
+}
+
+// setFields returns the JSON value of every field a config sets to something other than its zero value
+func setFields(config any) (map[string]any, error) {
+	fields, err := toMap(config)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, v := range fields {
+		if isZero(v) {
+			delete(fields, key)
+		}
+	}
+
+	return fields, nil
+}
+
+func isZero(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// decode converts a raw file entry into a typed JetStream config and returns the
+// normalized value of every key the entry sets
+func decode(raw map[string]any, config any) (map[string]any, error) {
+	for key, v := range raw {
+		if !durationFields[key] {
+			continue
+		}
+		converted, err := toNanos(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		raw[key] = converted
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, err
+	}
+
+	// Round trip through the typed config so values compare like the ones returned by the server
+	normalized, err := toMap(config)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]any, len(raw))
+	for key := range raw {
+		fields[key] = normalized[key]
+	}
+
+	return fields, nil
+}
+
+// toNanos converts duration strings, or lists of them, to nanoseconds
+func toNanos(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		return int64(d), nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			converted, err := toNanos(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// toMap converts a config struct to its generic JSON representation
+func toMap(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
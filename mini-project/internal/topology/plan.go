@@ -0,0 +1,273 @@
+package topology
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Action is what applying a change does to the server
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// immutableStreamFields cannot be changed on an existing stream
+var immutableStreamFields = map[string]bool{
+	"retention": true,
+	"storage":   true,
+	"mirror":    true,
+	"first_seq": true,
+}
+
+// immutableConsumerFields cannot be changed on an existing consumer
+var immutableConsumerFields = map[string]bool{
+	"deliver_policy":  true,
+	"opt_start_seq":   true,
+	"opt_start_time":  true,
+	"ack_policy":      true,
+	"replay_policy":   true,
+	"max_waiting":     true,
+	"mem_storage":     true,
+	"deliver_subject": true,
+}
+
+// FieldDiff is one config field that differs between the server and the file
+type FieldDiff struct {
+	Field     string
+	Current   any
+	Desired   any
+	Immutable bool
+}
+
+// Change is one step of a plan
+type Change struct {
+	Action   Action
+	Stream   string
+	Consumer string
+	Diffs    []FieldDiff
+
+	streamConfig   jetstream.StreamConfig
+	consumerConfig jetstream.ConsumerConfig
+}
+
+// Blocked reports whether the change touches a field that cannot be updated in place.
+// Such changes need the stream or consumer to be deleted and recreated by hand.
+func (c Change) Blocked() bool {
+	return slices.ContainsFunc(c.Diffs, func(d FieldDiff) bool { return d.Immutable })
+}
+
+func (c Change) target() string {
+	if c.Consumer != "" {
+		return "consumer " + c.Stream + "/" + c.Consumer
+	}
+	return "stream " + c.Stream
+}
+
+func (c Change) String() string {
+	target := c.target()
+
+	var b strings.Builder
+	switch {
+	case c.Blocked():
+		fmt.Fprintf(&b, "! %s (cannot be updated in place)\n", target)
+	case c.Action == Create:
+		fmt.Fprintf(&b, "+ %s\n", target)
+	case c.Action == Update:
+		fmt.Fprintf(&b, "~ %s\n", target)
+	case c.Action == Delete:
+		fmt.Fprintf(&b, "- %s\n", target)
+	}
+
+	for _, d := range c.Diffs {
+		suffix := ""
+		if d.Immutable {
+			suffix = " (immutable)"
+		}
+		fmt.Fprintf(&b, "    %s: %s -> %s%s\n", d.Field, formatValue(d.Field, d.Current), formatValue(d.Field, d.Desired), suffix)
+	}
+
+	return b.String()
+}
+
+// Plan compares the desired streams and consumers with the server. With prune, streams
+// and consumers missing from the desired topology are planned for deletion.
+func Plan(ctx context.Context, js jetstream.JetStream, desired []Stream, prune bool) ([]Change, error) {
+	var changes []Change
+	desiredNames := map[string]bool{}
+
+	for _, ds := range desired {
+		desiredNames[ds.Config.Name] = true
+
+		stream, err := js.Stream(ctx, ds.Config.Name)
+		if errors.Is(err, jetstream.ErrStreamNotFound) {
+			changes = append(changes, Change{Action: Create, Stream: ds.Config.Name, streamConfig: ds.Config})
+			for _, dc := range ds.Consumers {
+				changes = append(changes, Change{Action: Create, Stream: ds.Config.Name, Consumer: dc.Name(), consumerConfig: dc.Config})
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fetching stream %s: %w", ds.Config.Name, err)
+		}
+
+		merged, diffs, err := diff(stream.CachedInfo().Config, ds.fields, immutableStreamFields)
+		if err != nil {
+			return nil, fmt.Errorf("comparing stream %s: %w", ds.Config.Name, err)
+		}
+		if len(diffs) > 0 {
+			change := Change{Action: Update, Stream: ds.Config.Name, Diffs: diffs}
+			if err := json.Unmarshal(merged, &change.streamConfig); err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+		}
+
+		consumerChanges, err := planConsumers(ctx, stream, ds, prune)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, consumerChanges...)
+	}
+
+	if prune {
+		names := js.StreamNames(ctx)
+		for name := range names.Name() {
+			if !desiredNames[name] {
+				changes = append(changes, Change{Action: Delete, Stream: name})
+			}
+		}
+		if err := names.Err(); err != nil {
+			return nil, fmt.Errorf("listing streams: %w", err)
+		}
+	}
+
+	return changes, nil
+}
+
+func planConsumers(ctx context.Context, stream jetstream.Stream, ds Stream, prune bool) ([]Change, error) {
+	var changes []Change
+	desiredNames := map[string]bool{}
+
+	for _, dc := range ds.Consumers {
+		desiredNames[dc.Name()] = true
+
+		consumer, err := stream.Consumer(ctx, dc.Name())
+		if errors.Is(err, jetstream.ErrConsumerNotFound) {
+			changes = append(changes, Change{Action: Create, Stream: ds.Config.Name, Consumer: dc.Name(), consumerConfig: dc.Config})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fetching consumer %s/%s: %w", ds.Config.Name, dc.Name(), err)
+		}
+
+		merged, diffs, err := diff(consumer.CachedInfo().Config, dc.fields, immutableConsumerFields)
+		if err != nil {
+			return nil, fmt.Errorf("comparing consumer %s/%s: %w", ds.Config.Name, dc.Name(), err)
+		}
+		if len(diffs) > 0 {
+			change := Change{Action: Update, Stream: ds.Config.Name, Consumer: dc.Name(), Diffs: diffs}
+			if err := json.Unmarshal(merged, &change.consumerConfig); err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	if prune {
+		names := stream.ConsumerNames(ctx)
+		for name := range names.Name() {
+			if !desiredNames[name] {
+				changes = append(changes, Change{Action: Delete, Stream: ds.Config.Name, Consumer: name})
+			}
+		}
+		if err := names.Err(); err != nil {
+			return nil, fmt.Errorf("listing consumers of %s: %w", ds.Config.Name, err)
+		}
+	}
+
+	return changes, nil
+}
+
+// diff compares the desired fields with the current server config. It returns the
+// current config overlaid with the desired fields, so settings the file leaves out keep
+// their server values when the change is applied.
+func diff(current any, desired map[string]any, immutable map[string]bool) ([]byte, []FieldDiff, error) {
+	currentMap, err := toMap(current)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var diffs []FieldDiff
+	for _, field := range slices.Sorted(maps.Keys(desired)) {
+		if !matches(currentMap[field], desired[field]) {
+			diffs = append(diffs, FieldDiff{
+				Field:     field,
+				Current:   currentMap[field],
+				Desired:   desired[field],
+				Immutable: immutable[field],
+			})
+		}
+		currentMap[field] = desired[field]
+	}
+
+	merged, err := json.Marshal(currentMap)
+	return merged, diffs, err
+}
+
+// matches compares two JSON values. Maps only need the desired keys to match, because
+// the server adds its own entries to fields such as metadata.
+func matches(current, desired any) bool {
+	desiredMap, ok := desired.(map[string]any)
+	if !ok {
+		return reflect.DeepEqual(current, desired)
+	}
+
+	currentMap, ok := current.(map[string]any)
+	if !ok {
+		return len(desiredMap) == 0 && current == nil
+	}
+	for k, v := range desiredMap {
+		if !matches(currentMap[k], v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func formatValue(field string, v any) string {
+	if v == nil {
+		return "<unset>"
+	}
+
+	if durationFields[field] {
+		switch v := v.(type) {
+		case float64:
+			return time.Duration(v).String()
+		case []any:
+			durations := make([]string, len(v))
+			for i, item := range v {
+				durations[i] = formatValue(field, item)
+			}
+			return "[" + strings.Join(durations, ", ") + "]"
+		}
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/app"
+	"nats-project/internal/config"
+	"nats-project/internal/db"
+	"nats-project/internal/health"
+	"nats-project/internal/metrics"
+	ns "nats-project/internal/nats"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/outbox"
+	"nats-project/internal/retry"
+	"nats-project/internal/tracing"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Service is a service handling the messages of one ORDERS role, like inventory or payment
+type Service struct {
+	// Name identifies the service in traces
+	Name string
+	// ConnectionName identifies the service's NATS connection
+	ConnectionName string
+	Role           orderstream.Role
+	MetricsAddr    string
+	// Handler builds the message handler once postgres is connected. The handler writes the
+	// messages it publishes to the outbox, the relay of the service sends them.
+	Handler func(pgPool *pgxpool.Pool) Handler
+}
+
+// Run connects the service to postgres and NATS and handles the messages of its role until it is
+// shut down. Components are stopped in reverse order: metrics server, consumers, outbox relay,
+// NATS, postgres, tracing.
+func Run(cfg config.Config, s Service) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service := app.New(cfg.Shutdown.Timeout)
+
+	shutdownTracing, err := tracing.Init(ctx, s.Name, cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("initializing tracing: %w", err)
+	}
+	service.Append(app.Hook{Name: "tracing", Stop: shutdownTracing})
+
+	pgPool, err := db.InitPostgresDB(ctx, cfg.Postgres)
+	if err != nil {
+		return fmt.Errorf("initializing postgres database: %w", err)
+	}
+	service.Append(app.Hook{Name: "postgres", Stop: func(context.Context) error {
+		pgPool.Close()
+		return nil
+	}})
+	metrics.RegisterPool(pgPool)
+
+	nc, err := ns.InitNATS(s.ConnectionName, cfg.NATS)
+	if err != nil {
+		return fmt.Errorf("initializing NATS connection: %w", err)
+	}
+	service.Append(app.Hook{Name: "nats", Stop: func(ctx context.Context) error {
+		return ns.Drain(ctx, nc)
+	}})
+	slog.Info("connected to NATS server", "url", nc.ConnectedUrl())
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("creating JetStream context: %w", err)
+	}
+
+	// Every tenant has a consumer of its own, so a backlog of one tenant does not hold up the others
+	consumers, err := s.Role.Consumers(ctx, js, orderstream.Tenants(cfg.Tenants))
+	if err != nil {
+		return fmt.Errorf("looking up consumers: %w", err)
+	}
+
+	service.Append(app.Goroutine("outbox relay", outbox.NewRelay(pgPool, js, cfg.Outbox).Run))
+	policies := retry.NewPolicies(cfg.Retry)
+	handle := s.Handler(pgPool)
+	for _, consumer := range consumers {
+		service.Append(Consume(consumer.CachedInfo().Name, consumer, policies, handle))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/livez", health.Liveness(cfg.Health.Timeout, nc).Handler())
+	mux.Handle("/readyz", health.Readiness(cfg.Health.Timeout, pgPool, nc, js, orderstream.StreamName, s.Role.Names(orderstream.Tenants(cfg.Tenants))...).Handler())
+	service.Append(app.HTTPServer("metrics server", &http.Server{
+		Addr:    s.MetricsAddr,
+		Handler: mux,
+	}))
+
+	return service.Run(context.Background())
+}
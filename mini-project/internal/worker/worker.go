@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"nats-project/internal/app"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	ns "nats-project/internal/nats"
+	"nats-project/internal/retry"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// handleTimeout bounds the handling of a single message, well below the consumer ack wait
+const handleTimeout = 10 * time.Second
+
+// KeepAlive reports progress on the messages every third of the ack wait until stop is called,
+// so messages held longer than the ack wait, like the messages of a batch, are not redelivered
+// while they are still handled
+func KeepAlive(msgs ...jetstream.Msg) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(retry.AckWait / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, msg := range msgs {
+					msg.InProgress()
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Handler processes one message. Its error settles the message with the retry policy of
+// the message subject, errors wrapped with retry.Permanent terminate it.
+type Handler func(ctx context.Context, msg jetstream.Msg) error
+
+// Consume is a lifecycle hook that hands the messages of a durable consumer to handle one at a time.
+// Stopping it stops pulling and waits until the messages already pulled are settled.
+func Consume(name string, consumer jetstream.Consumer, policies retry.Policies, handle Handler) app.Hook {
+	var cc jetstream.ConsumeContext
+
+	return app.Hook{
+		Name: name,
+		Start: func(context.Context) error {
+			var err error
+			// The ack wait of a message starts when it is pulled, so only one more message waits
+			// while another is handled
+			cc, err = consumer.Consume(func(msg jetstream.Msg) {
+				process(msg, policies, handle)
+			}, jetstream.PullMaxMessages(2))
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			cc.Drain()
+			select {
+			case <-cc.Closed():
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+func process(msg jetstream.Msg, policies retry.Policies, handle Handler) {
+	metrics.MessageReceived(msg.Subject())
+
+	// Continue the trace and request id of whoever published the message
+	ctx, span := ns.StartConsumeSpan(context.Background(), msg)
+	ctx, _ = logging.WithMessage(ctx, msg)
+	ctx, cancel := context.WithTimeout(ctx, handleTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := handle(ctx, msg)
+	metrics.ObserveHandling(msg.Subject(), time.Since(start))
+	ns.EndSpan(span, err)
+
+	if err = policies.For(msg.Subject()).Settle(ctx, msg, err); err != nil {
+		logging.FromContext(ctx).Error("error settling message", logging.KeyError, err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"nats-project/internal/adaptive"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	ns "nats-project/internal/nats"
+	"nats-project/internal/order"
+	"nats-project/internal/retry"
+	"nats-project/internal/saga"
+	"nats-project/internal/worker"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// pullBatches is pull for batch mode, each fetch is handed to one worker as a whole.
+// Messages already waiting are taken without waiting, only an idle consumer blocks for up to fetchMaxWait.
+func pullBatches(ctx context.Context, consumer jetstream.Consumer, batchChan chan<- []jetstream.Msg, controller *adaptive.Controller) {
+	for ctx.Err() == nil {
+		n := controller.Reserve()
+		if n == 0 {
+			sleep(ctx, pullBackoff)
+			continue
+		}
+
+		msgs, err := collect(consumer.FetchNoWait(n))
+		if err == nil && len(msgs) == 0 {
+			msgs, err = collect(consumer.Fetch(n, jetstream.FetchMaxWait(fetchMaxWait)))
+		}
+		controller.Unreserve(n - len(msgs))
+		if err != nil {
+			slog.Error("error fetching messages", logging.KeyError, err)
+			sleep(ctx, pullBackoff)
+		}
+
+		if len(msgs) > 0 {
+			batchChan <- msgs
+		}
+	}
+}
+
+// collect reads every message of a fetch
+func collect(batch jetstream.MessageBatch, err error) ([]jetstream.Msg, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []jetstream.Msg
+	for msg := range batch.Messages() {
+		metrics.MessageReceived(msg.Subject())
+		msgs = append(msgs, msg)
+	}
+	if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+		return msgs, err
+	}
+
+	return msgs, nil
+}
+
+// batchItem is a decoded message of a batch
+type batchItem struct {
+	msg     jetstream.Msg
+	ctx     context.Context
+	logger  *slog.Logger
+	payload events.OrderCreated
+}
+
+// processBatch moves the orders of a whole batch to PROCESSING and starts their sagas in one transaction and settles the
+// messages only after it committed. When the batch fails every message is processed on its own,
+// so one bad message cannot hold back the others.
+func processBatch(msgs []jetstream.Msg, pgxPool *pgxpool.Pool, policies retry.Policies) {
+	ctx, span := ns.StartConsumeBatchSpan(context.Background(), msgs)
+	start := time.Now()
+	stop := worker.KeepAlive(msgs...)
+	defer stop()
+
+	items := make([]batchItem, 0, len(msgs))
+	for _, msg := range msgs {
+		msgCtx, logger := logging.WithMessage(ctx, msg)
+		payload, err := decodeOrderCreated(msgCtx, msg)
+		if err != nil {
+			settle(msgCtx, msg, policies, err)
+			continue
+		}
+
+		msgCtx, logger = logging.With(msgCtx, logging.KeyOrderID, payload.OrderID)
+		items = append(items, batchItem{msg: msg, ctx: msgCtx, logger: logger, payload: payload})
+	}
+
+	results, err := transitionBatch(ctx, pgxPool, items)
+	ns.EndSpan(span, err)
+	if err != nil {
+		slog.Warn("error processing batch, falling back to one message at a time",
+			"size", len(items), logging.KeyError, err)
+		for _, item := range items {
+			processMessage(item.msg, pgxPool, policies)
+		}
+		return
+	}
+
+	for i, item := range items {
+		metrics.ObserveHandling(item.msg.Subject(), time.Since(start))
+		settle(item.ctx, item.msg, policies, transitionOutcome(item.logger, results[i]))
+	}
+}
+
+// transitionBatch applies the status updates of a batch and starts the sagas of the updated orders in one transaction
+func transitionBatch(ctx context.Context, pgxPool *pgxpool.Pool, items []batchItem) ([]error, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.payload.OrderID
+	}
+
+	tx, err := pgxPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	results, err := order.TransitionBatch(ctx, tx, ids, order.Pending, order.Processing)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each saga command continues the trace of its own message
+	for i, item := range items {
+		if results[i] != nil {
+			continue
+		}
+		if err = saga.Start(item.ctx, tx, item.payload); err != nil {
+			return nil, err
+		}
+	}
+
+	// Results of a batch whose commit failed say nothing about the rows, so the whole batch fails
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func settle(ctx context.Context, msg jetstream.Msg, policies retry.Policies, err error) {
+	if err = policies.For(msg.Subject()).Settle(ctx, msg, err); err != nil {
+		logging.FromContext(ctx).Error("error settling message", logging.KeyError, err)
+	}
+}
@@ -2,12 +2,28 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"nats-project/internal/adaptive"
+	"nats-project/internal/app"
+	"nats-project/internal/config"
 	"nats-project/internal/db"
+	"nats-project/internal/events"
+	"nats-project/internal/health"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
 	ns "nats-project/internal/nats"
+	"nats-project/internal/order"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/outbox"
+	"nats-project/internal/projector"
+	"nats-project/internal/retry"
+	"nats-project/internal/saga"
+	"nats-project/internal/statuscache"
+	"nats-project/internal/tracing"
+	"nats-project/internal/worker"
+	"net/http"
 	"os"
-	"os/signal"
 	"sync"
 	"time"
 
@@ -16,99 +32,242 @@ import (
 )
 
 func main() {
-	var wg sync.WaitGroup
-	// Setup graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("consumers", cfg.Logging)
 
-	// Channel for workerpool
-	msgChan := make(chan jetstream.Msg, 100)
+	// Components are stopped in reverse order: metrics server, the pull loop and workers of each consumer, status cache, outbox relay, NATS, postgres, tracing
+	service := app.New(cfg.Shutdown.Timeout)
 
-	// Initialize NATS Connection
-	nc, err := ns.InitNATS("Consumer-1")
+	// Initialize Tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "consumers", cfg.Tracing)
 	if err != nil {
-		log.Fatal("error initializing NATS connection:", err)
+		logging.Fatal("error initializing tracing", logging.KeyError, err)
 		return
 	}
-	defer nc.Drain()
-	log.Println("connected to NATS server:", nc.ConnectedUrl())
+	service.Append(app.Hook{Name: "tracing", Stop: shutdownTracing})
 
 	// Initialize PostgreSQL Connection
-	pgPool, err := db.InitPostgresDB(context.Background())
+	pgPool, err := db.InitPostgresDB(context.Background(), cfg.Postgres)
 	if err != nil {
-		log.Fatal("error initializing postgres database:", err)
+		logging.Fatal("error initializing postgres database", logging.KeyError, err)
 		return
 	}
-	defer pgPool.Close()
+	service.Append(app.Hook{Name: "postgres", Stop: func(context.Context) error {
+		pgPool.Close()
+		return nil
+	}})
+	metrics.RegisterPool(pgPool)
 
-	// Setup workerpool
-	numWorkers := 5
-	for range numWorkers {
-		wg.Go(func() {
-			processMessages(msgChan, pgPool)
-		})
+	// Initialize NATS Connection
+	nc, err := ns.InitNATS("Consumer-1", cfg.NATS)
+	if err != nil {
+		logging.Fatal("error initializing NATS connection", logging.KeyError, err)
+		return
 	}
+	service.Append(app.Hook{Name: "nats", Stop: func(ctx context.Context) error {
+		return ns.Drain(ctx, nc)
+	}})
+	slog.Info("connected to NATS server", "url", nc.ConnectedUrl())
 
 	// Create JetStream Context
 	js, err := jetstream.New(nc)
 	if err != nil {
-		log.Fatal("error creating JetStream context:", err)
+		logging.Fatal("error creating JetStream context", logging.KeyError, err)
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	consumer, err := js.Consumer(ctx, "ORDERS", "ORDER_CONSUMER")
+	// Every tenant has a consumer of its own, so a backlog of one tenant does not hold up the others
+	consumers, err := orderstream.Orders.Consumers(ctx, js, orderstream.Tenants(cfg.Tenants))
 	if err != nil {
-		log.Fatal("error subscribing to subject:", err)
+		logging.Fatal("error subscribing to subject", logging.KeyError, err)
 		return
 	}
 
-	cctx, err := consumer.Consume(func(msg jetstream.Msg) {
-		msgChan <- msg
-	})
+	// Start the outbox relay which publishes the first command of every saga started here
+	service.Append(app.Goroutine("outbox relay", outbox.NewRelay(pgPool, js, cfg.Outbox).Run))
+
+	// Keep the order status bucket in step with the order history
+	cache, err := statuscache.New(ctx, js)
+	if err != nil {
+		logging.Fatal("error opening order status cache", logging.KeyError, err)
+		return
+	}
+	historyConsumer, err := js.Consumer(ctx, projector.StreamName, statuscache.ConsumerName)
 	if err != nil {
-		log.Fatal("error creating consumer context:", err)
+		logging.Fatal("error subscribing to order history", logging.KeyError, err)
 		return
 	}
-	log.Println("consumer started, waiting for messages...")
+	policies := retry.NewPolicies(cfg.Retry)
+	service.Append(worker.Consume("status cache", historyConsumer, policies, cache.Handle))
 
-	<-quit
-	log.Println("shutting down consumer...")
-	cctx.Drain()
-	close(msgChan)
-	wg.Wait()
-	log.Println("consumer shut down gracefully")
+	for _, consumer := range consumers {
+		consume(service, cfg.Consumer, consumer, pgPool, policies)
+	}
+
+	// Start the metrics and health checks server
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/livez", health.Liveness(cfg.Health.Timeout, nc).Handler())
+	readiness := health.Readiness(cfg.Health.Timeout, pgPool, nc, js, orderstream.StreamName, orderstream.Orders.Names(orderstream.Tenants(cfg.Tenants))...).
+		Add("consumer "+statuscache.ConsumerName, health.Consumer(js, projector.StreamName, statuscache.ConsumerName))
+	mux.Handle("/readyz", readiness.Handler())
+	service.Append(app.HTTPServer("metrics server", &http.Server{
+		Addr:    cfg.Consumer.MetricsAddr,
+		Handler: mux,
+	}))
+
+	if err := service.Run(context.Background()); err != nil {
+		logging.Fatal("error running consumer", logging.KeyError, err)
+		return
+	}
+	slog.Info("consumer shut down gracefully")
 }
 
-func processMessages(msgChan chan jetstream.Msg, pgxPool *pgxpool.Pool) {
-	for msg := range msgChan {
-		var payload struct {
-			ID string `json:"id"`
+// consume runs the worker pool of one consumer, its size and the pull batch size follow an adaptive
+// controller of its own. Its pull loop is stopped before its workers, which settle what was pulled.
+func consume(service *app.App, cfg config.Consumer, consumer jetstream.Consumer, pgPool *pgxpool.Pool, policies retry.Policies) {
+	name := consumer.CachedInfo().Name
+	controller := adaptive.NewController(name, cfg, consumer.CachedInfo().Config.MaxAckPending)
+	var pool workerPool
+	var pullLoop func(ctx context.Context)
+	switch cfg.Mode {
+	case config.ConsumerModeBatch:
+		// Channel of fetched batches, each worker applies a whole batch in one transaction
+		batchChan := make(chan []jetstream.Msg, cfg.QueueSize)
+		metrics.RegisterQueueDepth(name, func() int { return len(batchChan) })
+		pool = adaptive.NewPool(batchChan, cfg.MaxWorkers, func(msgs []jetstream.Msg) {
+			start := time.Now()
+			processBatch(msgs, pgPool, policies)
+			controller.Done(len(msgs), time.Since(start))
+		})
+		pullLoop = func(ctx context.Context) {
+			pullBatches(ctx, consumer, batchChan, controller)
+			close(batchChan)
 		}
-
-		err := json.Unmarshal(msg.Data(), &payload)
-		if err != nil {
-			log.Printf("error unmarshalling message: %v", err)
-			msg.Nak()
-			continue
+	default:
+		// Channel for workerpool
+		msgChan := make(chan jetstream.Msg, cfg.QueueSize)
+		metrics.RegisterQueueDepth(name, func() int { return len(msgChan) })
+		pool = adaptive.NewPool(msgChan, cfg.MaxWorkers, func(msg jetstream.Msg) {
+			start := time.Now()
+			processMessage(msg, pgPool, policies)
+			controller.Done(1, time.Since(start))
+		})
+		pullLoop = func(ctx context.Context) {
+			pull(ctx, consumer, msgChan, controller)
+			close(msgChan)
 		}
-		log.Printf("Processing order ID: %s", payload.ID)
+	}
+	pool.Resize(controller.Limits().Workers)
+	// Workers exit once the pull loop closed their channel and every queued message is settled
+	service.Append(app.Hook{Name: name + " workers", Stop: func(context.Context) error {
+		pool.Wait()
+		return nil
+	}})
 
-		_, err = pgxPool.Exec(context.Background(), "UPDATE orders SET status=$1 WHERE id=$2", "PROCESSING", payload.ID)
-		if err != nil {
-			log.Printf("error updating order status: %v", err)
-			msg.Nak()
-			continue
-		}
-		log.Printf("Order ID %s marked as PROCESSING", payload.ID)
+	// Stopping the pull loop stops fetching, the messages already fetched are still handed to the workers
+	service.Append(app.Goroutine(name+" pull loop", func(ctx context.Context) {
+		var wg sync.WaitGroup
+		wg.Go(func() {
+			controller.Run(ctx, sampleStats(consumer, pgPool), pool.Resize)
+		})
+		pullLoop(ctx)
+		wg.Wait()
+	}))
 
-		err = msg.Ack()
-		if err != nil {
-			log.Printf("error acknowledging message: %v", err)
-			continue
-		}
-		log.Printf("Acknowledged message for order ID: %s", payload.ID)
+	slog.Info("consumer starting", "consumer", name, "mode", cfg.Mode, "workers", controller.Limits().Workers,
+		"batch", controller.Limits().Batch)
+}
+
+func processMessage(msg jetstream.Msg, pgxPool *pgxpool.Pool, policies retry.Policies) {
+	// Continue the trace and request id of the request that created the order
+	ctx, span := ns.StartConsumeSpan(context.Background(), msg)
+	ctx, _ = logging.WithMessage(ctx, msg)
+	stop := worker.KeepAlive(msg)
+	defer stop()
+	start := time.Now()
+	err := handleOrderCreated(ctx, msg, pgxPool)
+	metrics.ObserveHandling(msg.Subject(), time.Since(start))
+	ns.EndSpan(span, err)
+	settle(ctx, msg, policies, err)
+}
+
+// handleOrderCreated moves the order to PROCESSING and starts its fulfillment saga in the same
+// transaction. Errors that redelivery cannot fix are marked permanent so the message is terminated instead of retried.
+func handleOrderCreated(ctx context.Context, msg jetstream.Msg, pgxPool *pgxpool.Pool) error {
+	payload, err := decodeOrderCreated(ctx, msg)
+	if err != nil {
+		return err
+	}
+	ctx, logger := logging.With(ctx, logging.KeyOrderID, payload.OrderID)
+	logger.Info("processing order")
+
+	tx, err := pgxPool.Begin(ctx)
+	if err != nil {
+		logger.Error("error starting postgres transaction", logging.KeyError, err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = order.Transition(ctx, tx, payload.OrderID, order.Pending, order.Processing)
+	if err != nil {
+		return transitionOutcome(logger, err)
+	}
+	if err = saga.Start(ctx, tx, payload); err != nil {
+		logger.Error("error starting order saga", logging.KeyError, err)
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		logger.Error("error committing order transaction", logging.KeyError, err)
+		return err
 	}
+
+	return transitionOutcome(logger, nil)
+}
+
+// decodeOrderCreated decodes the event of a message, failures are permanent
+func decodeOrderCreated(ctx context.Context, msg jetstream.Msg) (events.OrderCreated, error) {
+	logger := logging.FromContext(ctx)
+
+	env, err := events.Decode(msg.Subject(), msg.Headers(), msg.Data())
+	if err != nil {
+		logger.Error("error decoding event envelope", logging.KeyError, err)
+		return events.OrderCreated{}, retry.Permanent(err)
+	}
+
+	var payload events.OrderCreated
+	if err = env.Unmarshal(&payload); err != nil {
+		logger.Error("error unmarshalling message", logging.KeyError, err)
+		return events.OrderCreated{}, retry.Permanent(err)
+	}
+
+	return payload, nil
+}
+
+// transitionOutcome maps the result of moving an order to PROCESSING to the error the message is settled with
+func transitionOutcome(logger *slog.Logger, err error) error {
+	var transitionErr *order.TransitionError
+	switch {
+	case errors.As(err, &transitionErr) && transitionErr.Current == order.Processing:
+		// A redelivery of a message whose update already succeeded, only the ack was lost
+		logger.Info("order is already PROCESSING")
+	case errors.Is(err, order.ErrIllegalTransition), errors.Is(err, order.ErrNotFound):
+		// Retrying cannot make an illegal transition legal, so stop redelivery
+		logger.Warn("rejected order status update", logging.KeyError, err)
+		return retry.Permanent(err)
+	case err != nil:
+		logger.Error("error updating order status", logging.KeyError, err)
+		return err
+	default:
+		logger.Info("order marked as PROCESSING")
+	}
+
+	return nil
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"nats-project/internal/adaptive"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// fetchMaxWait bounds how long a fetch waits for messages, and so how long shutdown waits for the pull loop
+	fetchMaxWait = time.Second
+	// pullBackoff is the pause after a failed fetch or while every MaxAckPending slot is taken
+	pullBackoff = 100 * time.Millisecond
+)
+
+// workerPool is an adaptive.Pool of single messages or of batches
+type workerPool interface {
+	Resize(workers int)
+	Wait()
+}
+
+// pull fetches batches sized by the controller and hands their messages to the workers
+// until ctx is cancelled. It is the only sender on msgChan, so msgChan can be closed once it returns.
+func pull(ctx context.Context, consumer jetstream.Consumer, msgChan chan<- jetstream.Msg, controller *adaptive.Controller) {
+	for ctx.Err() == nil {
+		n := controller.Reserve()
+		if n == 0 {
+			sleep(ctx, pullBackoff)
+			continue
+		}
+
+		batch, err := consumer.Fetch(n, jetstream.FetchMaxWait(fetchMaxWait))
+		if err != nil {
+			controller.Unreserve(n)
+			slog.Error("error fetching messages", logging.KeyError, err)
+			sleep(ctx, pullBackoff)
+			continue
+		}
+
+		received := 0
+		for msg := range batch.Messages() {
+			received++
+			metrics.MessageReceived(msg.Subject())
+			msgChan <- msg
+		}
+		controller.Unreserve(n - received)
+
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			slog.Error("error receiving fetched messages", logging.KeyError, err)
+			sleep(ctx, pullBackoff)
+		}
+	}
+}
+
+// sampleStats reads the backlog and MaxAckPending of the consumer and the saturation of the postgres pool
+func sampleStats(consumer jetstream.Consumer, pgxPool *pgxpool.Pool) adaptive.Sampler {
+	return func(ctx context.Context) (adaptive.Stats, error) {
+		info, err := consumer.Info(ctx)
+		if err != nil {
+			return adaptive.Stats{}, err
+		}
+
+		stat := pgxPool.Stat()
+		return adaptive.Stats{
+			PoolSaturation: float64(stat.AcquiredConns()) / float64(stat.MaxConns()),
+			Pending:        info.NumPending,
+			MaxAckPending:  info.Config.MaxAckPending,
+		}, nil
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/db"
+	"nats-project/internal/dlq"
+	"nats-project/internal/logging"
+	"nats-project/internal/nats"
+	"nats-project/internal/tracing"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const usage = `usage: dlq [flags] <command>
+
+commands:
+  run              move messages that exhausted MaxDeliver into the ORDERS_DLQ stream
+  list             list dead-lettered messages
+  inspect <seq>    print a dead-lettered message with its headers
+  replay <seq>     re-queue an order created event and reset its order to PENDING
+  discard <seq>    delete a dead-lettered message
+`
+
+func main() {
+	fs := flag.NewFlagSet("dlq", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	start := fs.Uint64("start", 1, "first DLQ sequence to list")
+	limit := fs.Int("limit", 50, "maximum number of entries to list")
+
+	cfg, err := config.LoadFlagSet(fs, os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("dlq", cfg.Logging)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	shutdownTracing, err := tracing.Init(ctx, "dlq", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("error initializing tracing", logging.KeyError, err)
+		return
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("error flushing traces", logging.KeyError, err)
+		}
+	}()
+
+	pgPool, err := db.InitPostgresDB(ctx, cfg.Postgres)
+	if err != nil {
+		logging.Fatal("error initializing postgres database", logging.KeyError, err)
+		return
+	}
+	defer pgPool.Close()
+
+	nc, err := nats.InitNATS("DLQ", cfg.NATS)
+	if err != nil {
+		logging.Fatal("error initializing NATS connection", logging.KeyError, err)
+		return
+	}
+	defer nc.Drain()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		logging.Fatal("error creating JetStream context", logging.KeyError, err)
+		return
+	}
+
+	d := dlq.New(pgPool, js)
+
+	switch fs.Arg(0) {
+	case "run":
+		if err := d.Listen(ctx); err != nil {
+			logging.Fatal("error listening for advisories", logging.KeyError, err)
+		}
+		slog.Info("dlq listener shut down gracefully")
+
+	case "list":
+		entries, err := d.List(ctx, *start, *limit)
+		if err != nil {
+			logging.Fatal("error listing DLQ entries", logging.KeyError, err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEQ\tSUBJECT\tCONSUMER\tSTREAM SEQ\tDELIVERIES\tFAILED AT")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%s\n",
+				e.Sequence, e.Subject, e.Consumer, e.StreamSeq, e.Deliveries, e.FailedAt.Format(time.RFC3339))
+		}
+		w.Flush()
+
+	case "inspect":
+		e, err := d.Inspect(ctx, sequenceArg(fs))
+		if err != nil {
+			logging.Fatal("error fetching DLQ entry", logging.KeyError, err)
+		}
+
+		fmt.Printf("sequence:    %d\n", e.Sequence)
+		fmt.Printf("subject:     %s\n", e.Subject)
+		fmt.Printf("source:      %s/%s seq %d\n", e.Stream, e.Consumer, e.StreamSeq)
+		fmt.Printf("deliveries:  %d\n", e.Deliveries)
+		fmt.Printf("failed at:   %s\n", e.FailedAt.Format(time.RFC3339))
+		fmt.Println("headers:")
+		for k, v := range e.Header {
+			fmt.Printf("  %s: %v\n", k, v)
+		}
+		fmt.Printf("data:\n%s\n", e.Data)
+
+	case "replay":
+		if err := d.Replay(ctx, sequenceArg(fs)); err != nil {
+			logging.Fatal("error replaying DLQ entry", logging.KeyError, err)
+		}
+
+	case "discard":
+		seq := sequenceArg(fs)
+		if err := d.Discard(ctx, seq); err != nil {
+			logging.Fatal("error discarding DLQ entry", logging.KeyError, err)
+		}
+		slog.Info("discarded DLQ entry", "dlq_seq", seq)
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+func sequenceArg(fs *flag.FlagSet) uint64 {
+	seq, err := strconv.ParseUint(fs.Arg(1), 10, 64)
+	if err != nil {
+		logging.Fatal("invalid DLQ sequence", "seq", fs.Arg(1))
+	}
+	return seq
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/inventory"
+	"nats-project/internal/logging"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/worker"
+	"os"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("inventory", cfg.Logging)
+
+	err = worker.Run(cfg, worker.Service{
+		Name:           "inventory",
+		ConnectionName: "Inventory-Service",
+		Role:           orderstream.Inventory,
+		MetricsAddr:    cfg.Inventory.MetricsAddr,
+		// Reservation outcomes are written to the outbox together with the stock update
+		Handler: func(pgPool *pgxpool.Pool) worker.Handler {
+			return inventory.New(pgPool, cfg.Inventory).Handle
+		},
+	})
+	if err != nil {
+		logging.Fatal("error running inventory service", logging.KeyError, err)
+		return
+	}
+	slog.Info("inventory service shut down gracefully")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/db"
+	"nats-project/internal/logging"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: migrate [flags] <command>
+
+commands:
+  up          apply all pending migrations
+  down [n]    revert the last n applied migrations (default 1)
+  status      list migrations and when they were applied
+`
+
+func main() {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlagSet(fs, os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("migrate", cfg.Logging)
+
+	err = run(fs, cfg)
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		logging.Fatal("error running migrate command", "command", fs.Arg(0), logging.KeyError, err)
+		return
+	}
+}
+
+// errUsage reports a missing or unknown command
+var errUsage = errors.New("invalid usage")
+
+// run executes the command of the parsed flag set. It returns instead of exiting, so the
+// signal context is cancelled and the pool closed before main exits.
+func run(fs *flag.FlagSet, cfg config.Config) error {
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	pgPool, err := db.InitPostgresDB(ctx, cfg.Postgres)
+	if err != nil {
+		return fmt.Errorf("initializing postgres database: %w", err)
+	}
+	defer pgPool.Close()
+
+	migrator, err := db.NewMigrator(pgPool, db.Migrations)
+	if err != nil {
+		return fmt.Errorf("loading migrations: %w", err)
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("applying migrations: %w", err)
+		}
+		slog.Info("applied migrations", "applied", applied)
+
+	case "down":
+		steps := 1
+		if fs.NArg() > 1 {
+			steps, err = strconv.Atoi(fs.Arg(1))
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations to revert %q", fs.Arg(1))
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return fmt.Errorf("reverting migrations: %w", err)
+		}
+		slog.Info("reverted migrations", "reverted", reverted)
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("fetching migration status: %w", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return errUsage
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"nats-project/internal/outbox"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func cancelOrderHandler(pgxPool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		tenant, ok := callerTenant(c)
+		if !ok {
+			return
+		}
+		id := c.Param("id")
+		ctx, logger := logging.With(ctx, logging.KeyOrderID, id)
+
+		// Cancel the order and save its order cancelled event in a single transaction
+		tx, err := pgxPool.Begin(ctx)
+		if err != nil {
+			logger.Error("error starting postgres transaction", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel order"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// Orders of other tenants are answered like unknown ones
+		_, err = order.Get(ctx, tx, tenant, id)
+		if err == nil {
+			err = order.Transition(ctx, tx, id, order.Pending, order.Cancelled)
+		}
+		if errors.Is(err, order.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+		var transitionErr *order.TransitionError
+		if errors.As(err, &transitionErr) {
+			logger.Info("rejected order cancellation", logging.KeyError, transitionErr)
+			c.JSON(http.StatusConflict, gin.H{"error": "order in status " + string(transitionErr.Current) + " cannot be cancelled"})
+			return
+		}
+		if err != nil {
+			logger.Error("error cancelling order in postgres", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel order"})
+			return
+		}
+
+		event, err := events.New(eventSource, id, events.OrderCancelled{OrderID: id})
+		if err != nil {
+			logger.Error("error building order cancelled event", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel order"})
+			return
+		}
+
+		if err = outbox.Insert(ctx, tx, events.Subject(tenant, events.TypeOrderCancelled), event); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel order"})
+			return
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			logger.Error("error committing cancel order transaction", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel order"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "order cancelled", "order_id": id})
+	}
+}
@@ -1,14 +1,38 @@
 package api
 
 import (
+	"nats-project/internal/router"
+	"nats-project/internal/statuscache"
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/nats-io/nats.go/jetstream"
 )
 
-func RegisterRoutes(router *gin.Engine, pg *pgxpool.Pool, js jetstream.JetStream) {
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
-	})
-	router.POST("/order", saveOrderHandler(pg, js))
+// eventSource is the CloudEvents source of every event published by the order service
+const eventSource = "order-service"
+
+// RegisterRoutes adds the order routes, which must sit behind router.Authenticate.
+// Every handler only reads and writes the orders of the caller's tenant.
+func RegisterRoutes(routes gin.IRoutes, pg *pgxpool.Pool, cache *statuscache.Cache, streams *EventStreams, idempotencyTTL time.Duration) {
+	routes.POST("/order", saveOrderHandler(pg, idempotencyTTL))
+	routes.GET("/order/:id", getOrderHandler(pg))
+	routes.GET("/order/:id/status", orderStatusHandler(pg, cache))
+	routes.GET("/order/:id/events", orderEventsHandler(streams))
+	routes.GET("/order/:id/events/ws", orderEventsWebSocketHandler(streams))
+	routes.POST("/order/:id/cancel", cancelOrderHandler(pg))
+	routes.GET("/orders", listOrdersHandler(pg))
+}
+
+// callerTenant returns the tenant of the authenticated caller. A request without one means
+// the route was registered without router.Authenticate, it is rejected rather than left unscoped.
+func callerTenant(c *gin.Context) (string, bool) {
+	p, ok := router.PrincipalFrom(c.Request.Context())
+	if !ok || p.Tenant == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return "", false
+	}
+
+	return p.Tenant, true
 }
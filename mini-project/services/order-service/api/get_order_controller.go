@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func getOrderHandler(pgxPool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		tenant, ok := callerTenant(c)
+		if !ok {
+			return
+		}
+		id := c.Param("id")
+		ctx, logger := logging.With(ctx, logging.KeyOrderID, id)
+
+		o, err := order.Get(ctx, pgxPool, tenant, id)
+		if errors.Is(err, order.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+		if err != nil {
+			logger.Error("error fetching order from postgres", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch order"})
+			return
+		}
+
+		c.JSON(http.StatusOK, o)
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	idempotentReplayed   = "Idempotent-Replayed"
+	uniqueViolation      = "23505"
+)
+
+var (
+	errIdempotencyKeyNotFound = errors.New("idempotency key not found")
+	errIdempotencyKeyExists   = errors.New("idempotency key already exists")
+)
+
+// storedResponse is the response saved for an idempotency key
+type storedResponse struct {
+	requestHash string
+	statusCode  int
+	body        []byte
+}
+
+// hashRequest fingerprints a request body so a reused key with a different payload can be detected
+func hashRequest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Idempotency keys are chosen by clients, so they are only unique within a tenant. Expired keys
+// are treated as missing even before the cleanup deleted them.
+func getIdempotentResponse(ctx context.Context, pgxPool *pgxpool.Pool, tenant, key string) (storedResponse, error) {
+	var resp storedResponse
+	err := pgxPool.QueryRow(ctx, `SELECT request_hash, status_code, response FROM idempotency_keys
+		WHERE tenant_id = $1 AND key = $2 AND expires_at > now()`,
+		tenant, key).Scan(&resp.requestHash, &resp.statusCode, &resp.body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storedResponse{}, errIdempotencyKeyNotFound
+	}
+
+	return resp, err
+}
+
+// saveIdempotentResponse stores the response until ttl passed. An expired key is taken over,
+// errIdempotencyKeyExists means another request holds the key.
+func saveIdempotentResponse(ctx context.Context, tx pgx.Tx, tenant, key string, resp storedResponse, ttl time.Duration) error {
+	tag, err := tx.Exec(ctx, `INSERT INTO idempotency_keys (tenant_id, key, request_hash, status_code, response, expires_at)
+		VALUES ($1, $2, $3, $4, $5, now() + $6::interval)
+		ON CONFLICT (tenant_id, key) DO UPDATE SET request_hash = excluded.request_hash, status_code = excluded.status_code,
+			response = excluded.response, created_at = now(), expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at <= now()`,
+		tenant, key, resp.requestHash, resp.statusCode, resp.body, ttl)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errIdempotencyKeyExists
+	}
+
+	return nil
+}
+
+// IdempotencyCleaner deletes expired idempotency keys
+type IdempotencyCleaner struct {
+	pgxPool  *pgxpool.Pool
+	interval time.Duration
+}
+
+func NewIdempotencyCleaner(pgxPool *pgxpool.Pool, cfg config.Idempotency) *IdempotencyCleaner {
+	return &IdempotencyCleaner{pgxPool: pgxPool, interval: cfg.CleanupInterval}
+}
+
+// Run deletes the expired keys every interval until the context is cancelled
+func (c *IdempotencyCleaner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("idempotency key cleanup stopped")
+			return
+		case <-ticker.C:
+		}
+
+		tag, err := c.pgxPool.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()")
+		if err != nil {
+			slog.Error("error deleting expired idempotency keys", logging.KeyError, err)
+			continue
+		}
+		if tag.RowsAffected() > 0 {
+			slog.Info("deleted expired idempotency keys", "count", tag.RowsAffected())
+		}
+	}
+}
+
+// isUniqueViolation reports whether err is a postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
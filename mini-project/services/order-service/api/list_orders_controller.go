@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errInvalidLimit = errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+
+// listQuery is a page request of the order list, shared by the HTTP and NATS endpoints.
+// An empty tenant lists the orders of every tenant.
+type listQuery struct {
+	Tenant string       `json:"tenant_id"`
+	Status order.Status `json:"status"`
+	Cursor string       `json:"cursor"`
+	Limit  int          `json:"limit"`
+}
+
+// listPage is one page of the order list
+type listPage struct {
+	Orders     []order.Order `json:"orders"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+// validate checks the query and applies the default page size to an unset limit
+func (q *listQuery) validate() error {
+	if q.Limit == 0 {
+		q.Limit = defaultPageSize
+	}
+	if q.Limit < 1 || q.Limit > maxPageSize {
+		return errInvalidLimit
+	}
+	if q.Status != "" && !q.Status.Valid() {
+		return errors.New("unknown order status " + string(q.Status))
+	}
+
+	return nil
+}
+
+func listOrders(ctx context.Context, pgxPool *pgxpool.Pool, q listQuery) (listPage, error) {
+	// Fetch one extra row to know whether there is a next page
+	orders, err := order.List(ctx, pgxPool, q.Tenant, q.Status, q.Cursor, q.Limit+1)
+	if err != nil {
+		return listPage{}, err
+	}
+
+	page := listPage{Orders: orders}
+	if len(orders) > q.Limit {
+		page.Orders = orders[:q.Limit]
+		page.NextCursor = orders[q.Limit-1].ID
+	}
+
+	return page, nil
+}
+
+func listOrdersHandler(pgxPool *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		tenant, ok := callerTenant(c)
+		if !ok {
+			return
+		}
+
+		q := listQuery{Tenant: tenant, Status: order.Status(c.Query("status")), Cursor: c.Query("cursor")}
+		if l := c.Query("limit"); l != "" {
+			parsed, err := strconv.Atoi(l)
+			if err != nil || parsed < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidLimit.Error()})
+				return
+			}
+			q.Limit = parsed
+		}
+		if err := q.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		page, err := listOrders(ctx, pgxPool, q)
+		if err != nil {
+			logging.FromContext(ctx).Error("error listing orders from postgres", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list orders"})
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
+	"nats-project/internal/order"
+	"nats-project/internal/projector"
+	"nats-project/internal/statuscache"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// heartbeatInterval is how long a stream may be silent before the client is pinged,
+	// which keeps proxies from closing it and notices clients that went away
+	heartbeatInterval = 15 * time.Second
+
+	// writeTimeout bounds every write to a client. A client that cannot take an event in
+	// time is disconnected rather than buffered for, it can reconnect and gets the current status.
+	writeTimeout = 10 * time.Second
+
+	// streamBuffer is the number of events pulled ahead of a slow client
+	streamBuffer = 16
+
+	// maxClientMessage bounds what a WebSocket client can send, clients are not expected to send anything
+	maxClientMessage = 512
+)
+
+// statusEvent is one message of an order event stream
+type statusEvent struct {
+	ID      string       `json:"id"`
+	Status  order.Status `json:"status"`
+	Version int64        `json:"version"`
+}
+
+// EventStreams serves the live status of orders over SSE and WebSocket. Each stream reads
+// the history of its order with an ephemeral ordered consumer and ends once the order reaches
+// a terminal status, at most maxStreams streams are open at the same time.
+type EventStreams struct {
+	js      jetstream.JetStream
+	pgxPool *pgxpool.Pool
+	slots   chan struct{}
+
+	// done is closed by Close, which then waits for the open streams to end
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	active sync.WaitGroup
+}
+
+func NewEventStreams(js jetstream.JetStream, pgxPool *pgxpool.Pool, maxStreams int) *EventStreams {
+	return &EventStreams{
+		js:      js,
+		pgxPool: pgxPool,
+		slots:   make(chan struct{}, maxStreams),
+		done:    make(chan struct{}),
+	}
+}
+
+// Close ends the open streams and turns new ones away. The HTTP server does not wait for
+// hijacked WebSocket connections and would wait for SSE responses until its shutdown deadline.
+func (s *EventStreams) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	s.mu.Unlock()
+
+	ended := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(ended)
+	}()
+
+	select {
+	case <-ended:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// orderStream is an accepted stream of one order
+type orderStream struct {
+	streams  *EventStreams
+	current  statusEvent
+	messages jetstream.MessagesContext
+	logger   *slog.Logger
+}
+
+// open accepts a stream for the order of the request, or writes the error response and returns false
+func (s *EventStreams) open(c *gin.Context) (*orderStream, bool) {
+	tenant, ok := callerTenant(c)
+	if !ok {
+		return nil, false
+	}
+	id := c.Param("id")
+	ctx, logger := logging.With(c.Request.Context(), logging.KeyOrderID, id)
+
+	// The id becomes part of the consumer's filter subject, so wildcards must not get through
+	if !order.ValidID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": order.ErrInvalidID.Error()})
+		return nil, false
+	}
+
+	if !s.acquire() {
+		metrics.StreamRejected()
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "too many open event streams"})
+		return nil, false
+	}
+	stream := &orderStream{streams: s, logger: logger}
+
+	// The consumer is created before the status is read, so no change between the two is missed
+	messages, err := s.follow(ctx, id)
+	if err != nil {
+		stream.close()
+		logger.Error("error creating order history consumer", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stream order events"})
+		return nil, false
+	}
+	stream.messages = messages
+
+	lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	status, version, err := order.CurrentStatus(lookupCtx, s.pgxPool, tenant, id)
+	if errors.Is(err, order.ErrNotFound) {
+		stream.close()
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return nil, false
+	}
+	if err != nil {
+		stream.close()
+		logger.Error("error fetching order status from postgres", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stream order events"})
+		return nil, false
+	}
+	stream.current = statusEvent{ID: id, Status: status, Version: version}
+
+	return stream, true
+}
+
+// acquire takes a stream slot without waiting, it fails at the stream limit and after Close
+func (s *EventStreams) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		s.active.Add(1)
+		metrics.StreamOpened()
+		return true
+	default:
+		return false
+	}
+}
+
+// follow reads the history events of an order published from now on
+func (s *EventStreams) follow(ctx context.Context, id string) (jetstream.MessagesContext, error) {
+	consumer, err := s.js.OrderedConsumer(ctx, projector.StreamName, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{events.HistorySubjectPrefix + id + ".>"},
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages are only pulled as the client takes them, a slow client leaves the rest in the stream
+	return consumer.Messages(jetstream.PullMaxMessages(streamBuffer))
+}
+
+func (o *orderStream) close() {
+	if o.messages != nil {
+		o.messages.Stop()
+	}
+	<-o.streams.slots
+	metrics.StreamClosed()
+	o.streams.active.Done()
+}
+
+// eventWriter sends the messages of a stream to an SSE or WebSocket client
+type eventWriter interface {
+	send(e statusEvent) error
+	ping() error
+}
+
+// run sends the current status and then every change of it until the order reaches a
+// terminal status, ctx is cancelled or the service shuts down
+func (o *orderStream) run(ctx context.Context, w eventWriter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopOnClose := context.AfterFunc(ctx, o.messages.Stop)
+	defer stopOnClose()
+	go func() {
+		select {
+		case <-o.streams.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	last := o.current
+	if err := w.send(last); err != nil {
+		return err
+	}
+
+	for !last.Status.IsTerminal() {
+		msg, err := o.messages.Next(jetstream.NextMaxWait(heartbeatInterval))
+		if errors.Is(err, nats.ErrTimeout) {
+			if err = w.ping(); err != nil {
+				return err
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		env, err := events.Decode(msg.Subject(), msg.Headers(), msg.Data())
+		if err != nil {
+			o.logger.Warn("skipping undecodable order history event", logging.KeyError, err)
+			continue
+		}
+		entry, ok, err := statuscache.FromHistory(env)
+		if err != nil {
+			o.logger.Warn("skipping undecodable order history event", logging.KeyError, err)
+			continue
+		}
+		// History events may be published out of order, older versions than the one sent are stale
+		if !ok || entry.Version <= last.Version {
+			continue
+		}
+
+		last = statusEvent{ID: last.ID, Status: entry.Status, Version: entry.Version}
+		if err = w.send(last); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// orderEventsHandler streams the status of an order as Server-Sent Events
+func orderEventsHandler(streams *EventStreams) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stream, ok := streams.open(c)
+		if !ok {
+			return
+		}
+		defer stream.close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		// Keeps nginx from buffering the stream
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		w := &sseWriter{c: c, rc: http.NewResponseController(c.Writer)}
+		if err := stream.run(c.Request.Context(), w); err != nil {
+			stream.logger.Info("order event stream ended", logging.KeyError, err)
+		}
+	}
+}
+
+type sseWriter struct {
+	c  *gin.Context
+	rc *http.ResponseController
+}
+
+func (w *sseWriter) send(e statusEvent) error {
+	return w.write(func() {
+		w.c.SSEvent("status", e)
+	})
+}
+
+func (w *sseWriter) ping() error {
+	return w.write(func() {
+		// Lines starting with a colon are comments, which clients ignore
+		w.c.Writer.WriteString(": ping\n\n")
+	})
+}
+
+func (w *sseWriter) write(fn func()) error {
+	if err := w.rc.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	fn()
+
+	return w.rc.Flush()
+}
+
+// orderEventsWebSocketHandler streams the status of an order over a WebSocket, one JSON text message per status
+func orderEventsWebSocketHandler(streams *EventStreams) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stream, ok := streams.open(c)
+		if !ok {
+			return
+		}
+		defer stream.close()
+
+		// Server skips the Origin check of websocket.Handler, the stream is as public as GET /order/:id
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = maxClientMessage
+			ctx, cancel := context.WithCancel(c.Request.Context())
+			defer cancel()
+
+			// A hijacked connection does not cancel the request context, reading notices the client leaving
+			go func() {
+				defer cancel()
+				for {
+					var discard []byte
+					if err := websocket.Message.Receive(ws, &discard); err != nil {
+						return
+					}
+				}
+			}()
+
+			if err := stream.run(ctx, &wsWriter{ws: ws}); err != nil {
+				stream.logger.Info("order event stream ended", logging.KeyError, err)
+			}
+		}}.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// pingCodec sends WebSocket ping frames, the client answers them without the application seeing it
+var pingCodec = websocket.Codec{Marshal: func(any) ([]byte, byte, error) {
+	return nil, websocket.PingFrame, nil
+}}
+
+type wsWriter struct {
+	ws *websocket.Conn
+}
+
+func (w *wsWriter) send(e statusEvent) error {
+	if err := w.ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	if err := websocket.JSON.Send(w.ws, e); err != nil {
+		return fmt.Errorf("sending order status: %w", err)
+	}
+
+	return nil
+}
+
+func (w *wsWriter) ping() error {
+	if err := w.ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+
+	return pingCodec.Send(w.ws, nil)
+}
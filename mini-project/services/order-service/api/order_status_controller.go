@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"nats-project/internal/statuscache"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// orderStatus is the reply of the status endpoints
+type orderStatus struct {
+	ID     string       `json:"id"`
+	Status order.Status `json:"status"`
+}
+
+// lookupStatus serves the status of an order of a tenant from the KV cache and falls back to postgres
+// for orders the cache has not seen yet or when the bucket cannot be read. An empty tenant matches every tenant.
+func lookupStatus(ctx context.Context, pgxPool *pgxpool.Pool, cache *statuscache.Cache, tenant, id string) (orderStatus, error) {
+	entry, err := cache.Get(ctx, id)
+	if err == nil {
+		if tenant != "" && entry.TenantID != tenant {
+			return orderStatus{}, order.ErrNotFound
+		}
+		return orderStatus{ID: id, Status: entry.Status}, nil
+	}
+	if !errors.Is(err, statuscache.ErrNotFound) {
+		logging.FromContext(ctx).Warn("error reading order status cache, falling back to postgres", logging.KeyError, err)
+	}
+
+	o, err := order.Get(ctx, pgxPool, tenant, id)
+	if err != nil {
+		return orderStatus{}, err
+	}
+
+	return orderStatus{ID: o.ID, Status: o.Status}, nil
+}
+
+func orderStatusHandler(pgxPool *pgxpool.Pool, cache *statuscache.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		tenant, ok := callerTenant(c)
+		if !ok {
+			return
+		}
+		id := c.Param("id")
+		ctx, logger := logging.With(ctx, logging.KeyOrderID, id)
+
+		status, err := lookupStatus(ctx, pgxPool, cache, tenant, id)
+		if errors.Is(err, order.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			return
+		}
+		if err != nil {
+			logger.Error("error fetching order status", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch order status"})
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
@@ -2,54 +2,173 @@ package api
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"nats-project/internal/events"
+	"nats-project/internal/logging"
+	"nats-project/internal/order"
+	"nats-project/internal/outbox"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/nats-io/nats.go/jetstream"
 )
 
-type order struct {
+type createOrderRequest struct {
 	ID     string  `json:"id" binding:"required"`
 	Item   string  `json:"item" binding:"required"`
 	Amount float64 `json:"amount" binding:"required"`
-	Status string  `json:"status"`
 }
 
-func saveOrderHandler(pgxPool *pgxpool.Pool, js jetstream.JetStream) gin.HandlerFunc {
+func saveOrderHandler(pgxPool *pgxpool.Pool, idempotencyTTL time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 
-		var newOrder order
-		if err := c.ShouldBindJSON(&newOrder); err != nil {
-			log.Println("error binding order request payload", err)
+		tenant, ok := callerTenant(c)
+		if !ok {
+			return
+		}
+
+		var req createOrderRequest
+		if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+				return
+			}
+			logging.FromContext(ctx).Warn("error binding order request payload", logging.KeyError, err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request payload"})
 			return
 		}
-		newOrder.Status = "PENDING"
+		if !order.ValidID(req.ID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": order.ErrInvalidID.Error()})
+			return
+		}
+		ctx, logger := logging.With(ctx, logging.KeyOrderID, req.ID)
+
+		// Replay the stored response if this idempotency key was already used
+		idempotencyKey := c.GetHeader(idempotencyKeyHeader)
+		requestHash := hashRequest(c.MustGet(gin.BodyBytesKey).([]byte))
+		if idempotencyKey != "" && replayIdempotentResponse(ctx, c, pgxPool, tenant, idempotencyKey, requestHash) {
+			return
+		}
+
+		// Save order and its order created event in a single transaction,
+		// the outbox relay publishes the event to NATS JetStream after commit
+		tx, err := pgxPool.Begin(ctx)
+		if err != nil {
+			logger.Error("error starting postgres transaction", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		newOrder, err := order.Create(ctx, tx, order.Order{ID: req.ID, TenantID: tenant, Item: req.Item, Amount: req.Amount})
+		if isUniqueViolation(err) {
+			// A concurrent retry with the same key may have saved this order first
+			tx.Rollback(ctx)
+			if idempotencyKey != "" && replayIdempotentResponse(ctx, c, pgxPool, tenant, idempotencyKey, requestHash) {
+				return
+			}
 
-		// Save order to PostgreSQL
-		_, err := pgxPool.Exec(ctx, "INSERT INTO orders (id, item, amount, status) VALUES ($1, $2, $3, $4)",
-			newOrder.ID, newOrder.Item, newOrder.Amount, newOrder.Status)
+			// Order ids are unique across tenants. Like get and cancel, the answer for an order of
+			// another tenant does not confirm that the order exists.
+			_, err = order.Get(ctx, pgxPool, tenant, req.ID)
+			if errors.Is(err, order.ErrNotFound) {
+				logger.Warn("order id is used by another tenant")
+				c.JSON(http.StatusConflict, gin.H{"error": "order id is not available"})
+				return
+			}
+			if err != nil {
+				logger.Error("error fetching order from postgres", logging.KeyError, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
+				return
+			}
+			logger.Info("order already exists")
+			c.JSON(http.StatusConflict, gin.H{"error": "order already exists", "order_id": req.ID})
+			return
+		}
 		if err != nil {
-			log.Println("error saving order to postgres", err)
+			logger.Error("error saving order to postgres", logging.KeyError, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
 			return
 		}
 
-		// Publish order created event to NATS JetStream
-		data := []byte(`{"id": "` + newOrder.ID + `"}`)
-		ack, err := js.Publish(ctx, "orders.created", data)
+		event, err := events.New(eventSource, newOrder.ID, events.OrderCreated{
+			OrderID:  newOrder.ID,
+			TenantID: newOrder.TenantID,
+			Item:     newOrder.Item,
+			Amount:   newOrder.Amount,
+		})
 		if err != nil {
-			log.Println("error publishing order created event to NATS JetStream", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to publish order created event"})
+			logger.Error("error building order created event", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
 			return
 		}
-		log.Println("published order created event with ack:", ack)
 
-		c.JSON(http.StatusCreated, gin.H{"status": "order created", "order_id": newOrder.ID})
+		if err = outbox.Insert(ctx, tx, events.Subject(tenant, events.TypeOrderCreated), event); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
+			return
+		}
+
+		response := gin.H{"status": "order created", "order_id": newOrder.ID}
+		if idempotencyKey != "" {
+			body, _ := json.Marshal(response)
+			err = saveIdempotentResponse(ctx, tx, tenant, idempotencyKey, storedResponse{
+				requestHash: requestHash,
+				statusCode:  http.StatusCreated,
+				body:        body,
+			}, idempotencyTTL)
+			if err != nil && !errors.Is(err, errIdempotencyKeyExists) {
+				logger.Error("error saving idempotency key to postgres", logging.KeyError, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
+				return
+			}
+
+			// A concurrent request with the same key won the race, so answer with its response
+			if err != nil {
+				tx.Rollback(ctx)
+				if !replayIdempotentResponse(ctx, c, pgxPool, tenant, idempotencyKey, requestHash) {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
+				}
+				return
+			}
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			logger.Error("error committing order transaction", logging.KeyError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// replayIdempotentResponse writes the stored response for the tenant's key and reports whether it did
+func replayIdempotentResponse(ctx context.Context, c *gin.Context, pgxPool *pgxpool.Pool, tenant, key, requestHash string) bool {
+	logger := logging.FromContext(ctx)
+	resp, err := getIdempotentResponse(ctx, pgxPool, tenant, key)
+	if errors.Is(err, errIdempotencyKeyNotFound) {
+		return false
 	}
+	if err != nil {
+		logger.Error("error fetching idempotency key from postgres", logging.KeyError, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save order"})
+		return true
+	}
+
+	if resp.requestHash != requestHash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "idempotency key was already used with a different request"})
+		return true
+	}
+
+	logger.Info("replaying stored response for idempotency key", "idempotency_key", key)
+	c.Header(idempotentReplayed, strconv.FormatBool(true))
+	c.Data(resp.statusCode, "application/json; charset=utf-8", resp.body)
+	return true
 }
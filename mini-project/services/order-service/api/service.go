@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"nats-project/internal/logging"
+	ns "nats-project/internal/nats"
+	"nats-project/internal/order"
+	"nats-project/internal/statuscache"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ServiceSubjectPrefix is the group of the order query endpoints. They cannot live under orders.
+// because the ORDERS stream captures orders.* and orders.*.* and would answer requests with a publish ack.
+const ServiceSubjectPrefix = "svc.orders"
+
+// Error codes of the NATS endpoints, they follow the HTTP status of the matching gin handler
+const (
+	codeBadRequest = "400"
+	codeNotFound   = "404"
+	codeInternal   = "500"
+)
+
+// orderRequest is the body of the get and status requests. NATS callers are authorized by the
+// permissions of their connection, an optional tenant only narrows the request to that tenant.
+type orderRequest struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenant_id"`
+}
+
+// AddService registers the order query endpoints with the NATS micro framework:
+// svc.orders.get and svc.orders.status take {"id": "...", "tenant_id": "..."}, svc.orders.list takes
+// the tenant_id and the status, cursor and limit of GET /orders. Instances share a queue group, and the
+// service answers the $SRV.PING, $SRV.INFO and $SRV.STATS discovery requests.
+func AddService(nc *nats.Conn, pgxPool *pgxpool.Pool, cache *statuscache.Cache) (micro.Service, error) {
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:        "orders",
+		Version:     "1.0.0",
+		Description: "Order queries of the order service",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	group := svc.AddGroup(ServiceSubjectPrefix)
+	endpoints := map[string]func(ctx context.Context, req micro.Request){
+		"get":    getOrderEndpoint(pgxPool),
+		"list":   listOrdersEndpoint(pgxPool),
+		"status": orderStatusEndpoint(pgxPool, cache),
+	}
+	for name, handle := range endpoints {
+		if err := group.AddEndpoint(name, handler(handle)); err != nil {
+			svc.Stop()
+			return nil, err
+		}
+	}
+
+	return svc, nil
+}
+
+// handler runs an endpoint with a context carrying the trace and request id of the request
+func handler(handle func(ctx context.Context, req micro.Request)) micro.Handler {
+	return micro.HandlerFunc(func(req micro.Request) {
+		header := nats.Header(req.Headers())
+		ctx, span := ns.StartRequestSpan(context.Background(), req.Subject(), header)
+		defer span.End()
+		if id := header.Get(logging.HeaderRequestID); id != "" {
+			ctx = logging.WithRequestID(ctx, id)
+		}
+		ctx, _ = logging.With(ctx, logging.KeySubject, req.Subject())
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		handle(ctx, req)
+	})
+}
+
+func getOrderEndpoint(pgxPool *pgxpool.Pool) func(ctx context.Context, req micro.Request) {
+	return func(ctx context.Context, req micro.Request) {
+		o, ok := fetchOrder(ctx, pgxPool, req)
+		if ok {
+			req.RespondJSON(o)
+		}
+	}
+}
+
+func orderStatusEndpoint(pgxPool *pgxpool.Pool, cache *statuscache.Cache) func(ctx context.Context, req micro.Request) {
+	return func(ctx context.Context, req micro.Request) {
+		body, ok := decodeOrderRequest(req)
+		if !ok {
+			return
+		}
+		ctx, logger := logging.With(ctx, logging.KeyOrderID, body.ID)
+
+		status, err := lookupStatus(ctx, pgxPool, cache, body.TenantID, body.ID)
+		if errors.Is(err, order.ErrNotFound) {
+			req.Error(codeNotFound, "order not found", nil)
+			return
+		}
+		if err != nil {
+			logger.Error("error fetching order status", logging.KeyError, err)
+			req.Error(codeInternal, "failed to fetch order status", nil)
+			return
+		}
+
+		req.RespondJSON(status)
+	}
+}
+
+// decodeOrderRequest reads a get or status request, replying with an error when the id is missing
+func decodeOrderRequest(req micro.Request) (orderRequest, bool) {
+	var body orderRequest
+	if err := json.Unmarshal(req.Data(), &body); err != nil || body.ID == "" {
+		req.Error(codeBadRequest, `request must be {"id": "<order id>"}`, nil)
+		return orderRequest{}, false
+	}
+
+	return body, true
+}
+
+// fetchOrder reads the order a get request asks for, replying with an error when it cannot
+func fetchOrder(ctx context.Context, pgxPool *pgxpool.Pool, req micro.Request) (order.Order, bool) {
+	body, ok := decodeOrderRequest(req)
+	if !ok {
+		return order.Order{}, false
+	}
+	ctx, logger := logging.With(ctx, logging.KeyOrderID, body.ID)
+
+	o, err := order.Get(ctx, pgxPool, body.TenantID, body.ID)
+	if errors.Is(err, order.ErrNotFound) {
+		req.Error(codeNotFound, "order not found", nil)
+		return order.Order{}, false
+	}
+	if err != nil {
+		logger.Error("error fetching order from postgres", logging.KeyError, err)
+		req.Error(codeInternal, "failed to fetch order", nil)
+		return order.Order{}, false
+	}
+
+	return o, true
+}
+
+func listOrdersEndpoint(pgxPool *pgxpool.Pool) func(ctx context.Context, req micro.Request) {
+	return func(ctx context.Context, req micro.Request) {
+		var q listQuery
+		// An empty request lists the first page of every status
+		if len(req.Data()) > 0 {
+			if err := json.Unmarshal(req.Data(), &q); err != nil {
+				req.Error(codeBadRequest, "invalid request body: "+err.Error(), nil)
+				return
+			}
+		}
+		if err := q.validate(); err != nil {
+			req.Error(codeBadRequest, err.Error(), nil)
+			return
+		}
+
+		page, err := listOrders(ctx, pgxPool, q)
+		if err != nil {
+			logging.FromContext(ctx).Error("error listing orders from postgres", logging.KeyError, err)
+			req.Error(codeInternal, "failed to list orders", nil)
+			return
+		}
+
+		req.RespondJSON(page)
+	}
+}
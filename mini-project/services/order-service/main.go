@@ -2,71 +2,140 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"nats-project/internal/app"
+	"nats-project/internal/config"
 	"nats-project/internal/db"
+	"nats-project/internal/health"
+	"nats-project/internal/logging"
+	"nats-project/internal/metrics"
 	"nats-project/internal/nats"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/outbox"
 	"nats-project/internal/router"
+	"nats-project/internal/statuscache"
+	"nats-project/internal/tracing"
 	"nats-project/services/order-service/api"
 	"net/http"
 	"os"
-	"os/signal"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("order-service", cfg.Logging)
+
+	// Every order route needs an authenticated caller of a listed tenant, metrics and health checks stay open
+	authenticators, err := router.Authenticators(cfg.Auth)
+	if err != nil {
+		logging.Fatal("error configuring authentication", logging.KeyError, err)
+		return
+	}
+	authenticate := router.Authenticate(authenticators...)
+	requireTenant := router.RequireTenant(orderstream.Tenants(cfg.Tenants))
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	// Components are stopped in reverse order: event streams, HTTP server, NATS service, idempotency key cleanup, outbox relay, NATS, postgres, tracing
+	service := app.New(cfg.Shutdown.Timeout)
+
+	// Initialize Tracing
+	shutdownTracing, err := tracing.Init(ctx, "order-service", cfg.Tracing)
+	if err != nil {
+		logging.Fatal("error initializing tracing", logging.KeyError, err)
+		return
+	}
+	service.Append(app.Hook{Name: "tracing", Stop: shutdownTracing})
 
 	// Initialize PostgreSQL Connection
-	pgPool, err := db.InitPostgresDB(ctx)
+	pgPool, err := db.InitPostgresDB(ctx, cfg.Postgres)
 	if err != nil {
-		log.Fatal("error initializing postgres database:", err)
+		logging.Fatal("error initializing postgres database", logging.KeyError, err)
 		return
 	}
-	defer pgPool.Close()
+	service.Append(app.Hook{Name: "postgres", Stop: func(context.Context) error {
+		pgPool.Close()
+		return nil
+	}})
+	metrics.RegisterPool(pgPool)
 
 	// Initialize NATS Connection
-	nc, err := nats.InitNATS("Order-Service")
+	nc, err := nats.InitNATS("Order-Service", cfg.NATS)
 	if err != nil {
-		log.Fatal("error initializing NATS connection:", err)
+		logging.Fatal("error initializing NATS connection", logging.KeyError, err)
 		return
 	}
-	defer nc.Drain()
-	log.Println("connected to NATS server:", nc.ConnectedUrl())
+	service.Append(app.Hook{Name: "nats", Stop: func(ctx context.Context) error {
+		return nats.Drain(ctx, nc)
+	}})
+	slog.Info("connected to NATS server", "url", nc.ConnectedUrl())
 
 	// Create JetStream Context
 	js, err := jetstream.New(nc)
 	if err != nil {
-		log.Fatal("error creating JetStream context:", err)
+		logging.Fatal("error creating JetStream context", logging.KeyError, err)
 		return
 	}
 
+	// Status lookups are served from the order status bucket first
+	cache, err := statuscache.New(ctx, js)
+	if err != nil {
+		logging.Fatal("error opening order status cache", logging.KeyError, err)
+		return
+	}
+
+	// Start the outbox relay which publishes committed events to JetStream
+	service.Append(app.Goroutine("outbox relay", outbox.NewRelay(pgPool, js, cfg.Outbox).Run))
+	service.Append(app.Goroutine("idempotency key cleanup", api.NewIdempotencyCleaner(pgPool, cfg.Idempotency).Run))
+
+	// Serve the order queries over NATS next to HTTP
+	var querySvc micro.Service
+	service.Append(app.Hook{
+		Name: "nats service",
+		Start: func(context.Context) error {
+			var err error
+			querySvc, err = api.AddService(nc, pgPool, cache)
+			return err
+		},
+		Stop: func(context.Context) error {
+			return querySvc.Stop()
+		},
+	})
+
 	// Initialize Gin Router
-	router := router.NewGinRouter()
-	api.RegisterRoutes(router, pgPool, js)
+	router, err := router.NewGinRouter(cfg.HTTP, otelgin.Middleware("order-service"), metrics.GinMiddleware())
+	if err != nil {
+		logging.Fatal("error creating HTTP router", logging.KeyError, err)
+		return
+	}
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	router.GET("/livez", gin.WrapH(health.Liveness(cfg.Health.Timeout, nc).Handler()))
+	router.GET("/readyz", gin.WrapH(health.Readiness(cfg.Health.Timeout, pgPool, nc, js, orderstream.StreamName, orderstream.Orders.Names(orderstream.Tenants(cfg.Tenants))...).Handler()))
+	streams := api.NewEventStreams(js, pgPool, cfg.HTTP.MaxStreams)
+	api.RegisterRoutes(router.Group("/", authenticate, requireTenant), pgPool, cache, streams, cfg.Idempotency.TTL)
 
 	// Initialize Gin Server
-	server := &http.Server{
-		Addr:    ":8080",
+	service.Append(app.HTTPServer("http server", &http.Server{
+		Addr:    cfg.HTTP.Addr,
 		Handler: router,
-	}
+	}))
+
+	// Appended after the server so it stops first, the server's shutdown would wait for open streams
+	service.Append(app.Hook{Name: "event streams", Stop: streams.Close})
 
-	// Start the server
-	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			log.Println("error starting server:", err)
-		}
-	}()
-
-	// Wait for interrupt signal to gracefully shutdown the server
-	<-quit
-	if err := server.Shutdown(context.Background()); err != nil {
-		log.Println("error shutting down server:", err)
+	if err := service.Run(context.Background()); err != nil {
+		logging.Fatal("error running order service", logging.KeyError, err)
 		return
 	}
+	slog.Info("order service shut down gracefully")
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/payment"
+	"nats-project/internal/worker"
+	"os"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("payment", cfg.Logging)
+
+	err = worker.Run(cfg, worker.Service{
+		Name:           "payment",
+		ConnectionName: "Payment-Service",
+		Role:           orderstream.Payment,
+		MetricsAddr:    cfg.Payment.MetricsAddr,
+		// Payment outcomes are written to the outbox together with the payment row
+		Handler: func(pgPool *pgxpool.Pool) worker.Handler {
+			return payment.New(pgPool, cfg.Payment).Handle
+		},
+	})
+	if err != nil {
+		logging.Fatal("error running payment service", logging.KeyError, err)
+		return
+	}
+	slog.Info("payment service shut down gracefully")
+}
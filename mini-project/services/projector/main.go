@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/db"
+	"nats-project/internal/logging"
+	"nats-project/internal/nats"
+	"nats-project/internal/projector"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const usage = `usage: projector [flags] <command>
+
+commands:
+  follow     apply new order history events to the orders table, resuming from the checkpoint
+  rebuild    replay the whole order history into a shadow table and swap it in for the orders table
+  backfill   record a snapshot of every existing order in the order history
+`
+
+func main() {
+	fs := flag.NewFlagSet("projector", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadFlagSet(fs, os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("projector", cfg.Logging)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	pgPool, err := db.InitPostgresDB(ctx, cfg.Postgres)
+	if err != nil {
+		logging.Fatal("error initializing postgres database", logging.KeyError, err)
+		return
+	}
+	defer pgPool.Close()
+
+	nc, err := nats.InitNATS("Order-Projector", cfg.NATS)
+	if err != nil {
+		logging.Fatal("error initializing NATS connection", logging.KeyError, err)
+		return
+	}
+	defer nc.Drain()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		logging.Fatal("error creating JetStream context", logging.KeyError, err)
+		return
+	}
+	p := projector.New(pgPool, js)
+
+	switch fs.Arg(0) {
+	case "follow":
+		if err := p.Follow(ctx); err != nil {
+			logging.Fatal("error following order history", logging.KeyError, err)
+		}
+		slog.Info("projector stopped")
+
+	case "rebuild":
+		if err := p.Rebuild(ctx); err != nil {
+			logging.Fatal("error rebuilding orders table", logging.KeyError, err)
+		}
+
+	case "backfill":
+		recorded, err := p.Backfill(ctx)
+		if err != nil {
+			logging.Fatal("error backfilling order history", logging.KeyError, err)
+		}
+		slog.Info("recorded order snapshots", "orders", recorded)
+
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
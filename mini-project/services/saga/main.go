@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/logging"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/saga"
+	"nats-project/internal/worker"
+	"os"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("saga", cfg.Logging)
+
+	err = worker.Run(cfg, worker.Service{
+		Name:           "saga",
+		ConnectionName: "Saga-Coordinator",
+		Role:           orderstream.Saga,
+		MetricsAddr:    cfg.Saga.MetricsAddr,
+		// Saga commands are written to the outbox together with the saga state
+		Handler: func(pgPool *pgxpool.Pool) worker.Handler {
+			return saga.NewCoordinator(pgPool).Handle
+		},
+	})
+	if err != nil {
+		logging.Fatal("error running saga coordinator", logging.KeyError, err)
+		return
+	}
+	slog.Info("saga coordinator shut down gracefully")
+}
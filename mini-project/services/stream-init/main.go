@@ -2,92 +2,146 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"nats-project/internal/config"
 	"nats-project/internal/db"
+	"nats-project/internal/dlq"
+	"nats-project/internal/logging"
 	"nats-project/internal/nats"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/projector"
+	"nats-project/internal/retry"
+	"nats-project/internal/statuscache"
+	"os"
 	"time"
 
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+// migrateTimeout bounds the migrations, which may wait for the lock of another migrator
+// or rebuild an index of a large table
+const migrateTimeout = 10 * time.Minute
+
 func main() {
-	nc, err := nats.InitNATS("Jetstream-Initialization")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		slog.Error("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("stream-init", cfg.Logging)
+
+	nc, err := nats.InitNATS("Jetstream-Initialization", cfg.NATS)
 	if err != nil {
-		log.Println("error initializing NATS connection:", err)
+		slog.Error("error initializing NATS connection", logging.KeyError, err)
 		return
 	}
 	defer nc.Drain()
-	log.Println("connected to NATs server", nc.ConnectedUrl())
+	slog.Info("connected to NATS server", "url", nc.ConnectedUrl())
 
 	js, err := jetstream.New(nc)
 	if err != nil {
-		log.Println("error creating JetStream context:", err)
+		slog.Error("error creating JetStream context", logging.KeyError, err)
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	streamConfig := jetstream.StreamConfig{
-		Name:         "ORDERS",
-		Subjects:     []string{"orders.*"},
-		Storage:      jetstream.FileStorage,
-		Retention:    jetstream.WorkQueuePolicy,
-		MaxMsgs:      1000,
-		MaxAge:       time.Minute * 30,
-		Discard:      jetstream.DiscardOld,
-		MaxConsumers: 3,
+	tenants := orderstream.Tenants(cfg.Tenants)
+	stream, err := js.CreateOrUpdateStream(ctx, orderstream.StreamConfig(tenants))
+	if err != nil {
+		slog.Error("error creating stream", logging.KeyError, err)
+		return
 	}
 
-	stream, err := js.CreateOrUpdateStream(ctx, streamConfig)
+	streamInfo, err := stream.Info(ctx)
 	if err != nil {
-		log.Println("error creating stream:", err)
+		slog.Error("error fetching stream info", logging.KeyError, err)
 		return
 	}
+	slog.Info("stream created successfully", logging.KeyStream, streamInfo.Config.Name)
 
-	streamInfo, err := stream.Info(ctx)
+	// Every tenant gets a consumer per role, consumers of tenants removed from the config are left in place
+	policies := retry.NewPolicies(cfg.Retry)
+	for _, tenant := range tenants {
+		for _, role := range orderstream.Roles {
+			consumerConfig := role.ConsumerConfig(tenant, policies)
+			consumer, err := stream.CreateOrUpdateConsumer(ctx, consumerConfig)
+			if err != nil {
+				slog.Error("error creating consumer", "consumer", consumerConfig.Durable, logging.KeyError, err)
+				return
+			}
+			slog.Info("consumer created successfully", "consumer", consumer.CachedInfo().Name, logging.KeyTenant, tenant)
+		}
+	}
+
+	// Messages that exhaust the MaxDeliver of an ORDERS consumer are moved here by services/dlq
+	dlqStream, err := js.CreateOrUpdateStream(ctx, dlq.StreamConfig())
 	if err != nil {
-		log.Println("error fetching stream info:", err)
+		slog.Error("error creating dead-letter stream", logging.KeyError, err)
 		return
 	}
-	log.Println("stream created successfully:", streamInfo.Config.Name)
-
-	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
-		Durable:       "ORDER_CONSUMER",
-		AckPolicy:     jetstream.AckExplicitPolicy,
-		FilterSubject: "orders.created",
-		MaxAckPending: 5,
-		MaxDeliver:    2,
-		ReplayPolicy:  jetstream.ReplayInstantPolicy,
-	})
+	slog.Info("stream created successfully", logging.KeyStream, dlqStream.CachedInfo().Config.Name)
+
+	// Max deliveries advisories wait here until the dead-letter queue handled them
+	advisoryStream, err := js.CreateOrUpdateStream(ctx, dlq.AdvisoryStreamConfig())
 	if err != nil {
-		log.Println("error creating consumer:", err)
+		slog.Error("error creating advisory stream", logging.KeyError, err)
 		return
 	}
+	slog.Info("stream created successfully", logging.KeyStream, advisoryStream.CachedInfo().Config.Name)
 
-	consumerInfo, err := consumer.Info(ctx)
+	listener, err := advisoryStream.CreateOrUpdateConsumer(ctx, dlq.ListenerConsumerConfig())
 	if err != nil {
-		log.Println("error fetching consumer info:", err)
+		slog.Error("error creating consumer", "consumer", dlq.ListenerDurable, logging.KeyError, err)
 		return
 	}
-	log.Println("consumer created successfully:", consumerInfo.Name)
+	slog.Info("consumer created successfully", "consumer", listener.CachedInfo().Name)
 
-	pgPool, err := db.InitPostgresDB(ctx)
+	// Every order change is also recorded here, the projector rebuilds the orders table from it
+	historyStream, err := js.CreateOrUpdateStream(ctx, projector.StreamConfig())
 	if err != nil {
-		log.Println("error initializing postgres database:", err)
+		slog.Error("error creating order history stream", logging.KeyError, err)
+		return
+	}
+	slog.Info("stream created successfully", logging.KeyStream, historyStream.CachedInfo().Config.Name)
+
+	// The status cache follows the history with its own durable consumer
+	statusConsumer, err := historyStream.CreateOrUpdateConsumer(ctx, statuscache.ConsumerConfig(policies))
+	if err != nil {
+		slog.Error("error creating consumer", "consumer", statuscache.ConsumerName, logging.KeyError, err)
+		return
+	}
+	slog.Info("consumer created successfully", "consumer", statusConsumer.CachedInfo().Name)
+
+	statusBucket, err := js.CreateOrUpdateKeyValue(ctx, statuscache.BucketConfig())
+	if err != nil {
+		slog.Error("error creating order status bucket", logging.KeyError, err)
+		return
+	}
+	slog.Info("key value bucket created successfully", "bucket", statusBucket.Bucket())
+
+	pgPool, err := db.InitPostgresDB(ctx, cfg.Postgres)
+	if err != nil {
+		slog.Error("error initializing postgres database", logging.KeyError, err)
 		return
 	}
 	defer pgPool.Close()
 
-	_, err = pgPool.Exec(ctx, `CREATE TABLE orders (
-		id TEXT PRIMARY KEY,
-		item TEXT NOT NULL,
-		amount DOUBLE PRECISION NOT NULL,
-		status TEXT NOT NULL
-	);`)
+	migrator, err := db.NewMigrator(pgPool, db.Migrations)
+	if err != nil {
+		slog.Error("error loading migrations", logging.KeyError, err)
+		return
+	}
+
+	// The streams and the pool share the setup deadline, migrations get one of their own
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancelMigrate()
+
+	applied, err := migrator.Up(migrateCtx)
 	if err != nil {
-		log.Println("error creating orders table:", err)
+		slog.Error("error migrating postgres database", logging.KeyError, err)
 		return
 	}
-	log.Println("orders table created successfully in postgres database")
+	slog.Info("postgres database migrated successfully", "applied", applied)
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"nats-project/internal/config"
+	"nats-project/internal/dlq"
+	"nats-project/internal/logging"
+	"nats-project/internal/nats"
+	"nats-project/internal/orderstream"
+	"nats-project/internal/projector"
+	"nats-project/internal/retry"
+	"nats-project/internal/statuscache"
+	"nats-project/internal/topology"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func main() {
+	fs := flag.NewFlagSet("topology", flag.ContinueOnError)
+	file := fs.String("file", "", "YAML or JSON file describing the desired streams and consumers")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	prune := fs.Bool("prune", false, "delete streams and consumers that are not desired")
+	domain := fs.String("domain", "", "JetStream domain to manage, e.g. hub for the leafnode setup")
+	fromConfig := fs.Bool("from-config", false, "reconcile the streams of the mini-project, built from the configuration like stream-init does")
+
+	cfg, err := config.LoadFlagSet(fs, os.Args[1:])
+	if err != nil {
+		logging.Fatal("error loading configuration", logging.KeyError, err)
+		return
+	}
+	logging.Setup("topology", cfg.Logging)
+
+	// Without any desired stream -prune would delete everything
+	if *file == "" && !*fromConfig {
+		logging.Fatal("nothing to reconcile, pass -file or -from-config")
+		return
+	}
+
+	var desired []topology.Stream
+	if *file != "" {
+		desired, err = topology.Load(*file)
+		if err != nil {
+			logging.Fatal("error loading topology file", logging.KeyError, err)
+			return
+		}
+	}
+
+	// The streams of the mini-project come from the same code stream-init uses, so they cannot
+	// drift from what the services create
+	if *fromConfig {
+		configured, err := configStreams(cfg)
+		if err != nil {
+			logging.Fatal("error building topology from the configuration", logging.KeyError, err)
+			return
+		}
+		for _, s := range configured {
+			if slices.ContainsFunc(desired, func(d topology.Stream) bool { return d.Config.Name == s.Config.Name }) {
+				logging.Fatal("stream is built from the configuration, remove it from the topology file", logging.KeyStream, s.Config.Name)
+				return
+			}
+		}
+		desired = append(configured, desired...)
+	}
+
+	nc, err := nats.InitNATS("Topology-Reconciler", cfg.NATS)
+	if err != nil {
+		logging.Fatal("error initializing NATS connection", logging.KeyError, err)
+		return
+	}
+	defer nc.Drain()
+
+	// NewWithDomain rejects an empty domain, which means the local JetStream domain
+	js, err := jetstream.New(nc)
+	if *domain != "" {
+		js, err = jetstream.NewWithDomain(nc, *domain)
+	}
+	if err != nil {
+		logging.Fatal("error creating JetStream context", logging.KeyError, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	changes, err := topology.Plan(ctx, js, desired, *prune)
+	if err != nil {
+		logging.Fatal("error planning topology changes", logging.KeyError, err)
+		return
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("topology is up to date")
+		return
+	}
+
+	for _, c := range changes {
+		fmt.Print(c)
+	}
+
+	if *dryRun {
+		return
+	}
+
+	err = topology.Apply(ctx, js, changes)
+	if errors.Is(err, topology.ErrBlocked) {
+		slog.Warn("some changes were not applied, delete and recreate them by hand", logging.KeyError, err)
+		os.Exit(1)
+	}
+	if err != nil {
+		logging.Fatal("error applying topology changes", logging.KeyError, err)
+		return
+	}
+	fmt.Println("topology applied successfully")
+}
+
+// configStreams returns the streams and consumers stream-init creates for the configuration
+func configStreams(cfg config.Config) ([]topology.Stream, error) {
+	policies := retry.NewPolicies(cfg.Retry)
+
+	orders, err := orderstream.Topology(orderstream.Tenants(cfg.Tenants), policies)
+	if err != nil {
+		return nil, err
+	}
+	streams := []topology.Stream{orders}
+
+	others := []struct {
+		config    jetstream.StreamConfig
+		consumers []jetstream.ConsumerConfig
+	}{
+		{config: dlq.StreamConfig()},
+		{config: dlq.AdvisoryStreamConfig(), consumers: []jetstream.ConsumerConfig{dlq.ListenerConsumerConfig()}},
+		{config: projector.StreamConfig(), consumers: []jetstream.ConsumerConfig{statuscache.ConsumerConfig(policies)}},
+		{config: statuscache.BucketStreamConfig()},
+	}
+	for _, o := range others {
+		s, err := topology.NewStream(o.config, o.consumers...)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, s)
+	}
+
+	return streams, nil
+}